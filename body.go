@@ -0,0 +1,84 @@
+package httpcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// CanonicalizeBodyFunc returns the canonical form of a request body used to
+// generate the cache key, so that equivalent bodies share a cache entry.
+type CanonicalizeBodyFunc func(r *http.Request, body []byte) ([]byte, error)
+
+// CanonicalizeJSON is a CanonicalizeBodyFunc for JSON bodies. It drops
+// insignificant whitespace and sorts object keys.
+func CanonicalizeJSON(_ *http.Request, body []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after top-level value")
+	}
+	return json.Marshal(v)
+}
+
+// requestKey generates the primary key of the request. Keys of requests with
+// methods other than GET and HEAD include the method and the canonical body.
+// It returns false if the body can't be used for the key.
+func (m middleware) requestKey(r *http.Request) (uint64, bool) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return m.generateKey(r.URL), true
+	}
+
+	body, ok := readBody(r, m.maxRequestBodySize)
+	if !ok {
+		return 0, false
+	}
+	if m.canonicalizeBody != nil {
+		var err error
+		if body, err = m.canonicalizeBody(r, body); err != nil {
+			m.onError(fmt.Errorf("failed to canonicalize request body: %v", err))
+			return 0, false
+		}
+	}
+
+	urlCopy := *r.URL
+	sortURLParams(&urlCopy)
+	return m.keygen.Generate(r.Method + " " + urlCopy.String() + "\n" + string(body)), true
+}
+
+// readBody reads up to limit bytes of the request body and restores it for
+// the handler. A body read in full is kept in memory, and GetBody set for
+// copies of the request to read it again, e.g. after the server closed the
+// body. It returns false if the body exceeds the limit or can't be read.
+func readBody(r *http.Request, limit int64) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil || int64(len(body)) > limit {
+		r.Body = &readCloser{
+			Reader: io.MultiReader(bytes.NewReader(body), r.Body),
+			Closer: r.Body,
+		}
+		return body, false
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, true
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,208 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalizeJSON(t *testing.T) {
+	a, err := CanonicalizeJSON(nil, []byte(`{"b": 1, "a": [1.50, "x"]}`))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	b, err := CanonicalizeJSON(nil, []byte(`{"a":[1.50,"x"],"b":1}`))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if string(a) != string(b) {
+		t.Errorf("expected '%s' to be equal to '%s'", a, b)
+	}
+	if string(a) != `{"a":[1.50,"x"],"b":1}` {
+		t.Errorf("unexpected canonical form '%s'", a)
+	}
+
+	if _, err := CanonicalizeJSON(nil, []byte(`{"a":1} {"b":2}`)); err == nil {
+		t.Error("expected an error for trailing data")
+	}
+}
+
+func Test_readBody(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       string
+		expectedOk bool
+	}{
+		{name: "within limit", body: "hello", expectedOk: true},
+		{name: "over limit", body: "hello world", expectedOk: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(testCase.body))
+			if _, ok := readBody(r, 8); ok != testCase.expectedOk {
+				t.Errorf("expected %v, got %v", testCase.expectedOk, ok)
+			}
+			restored, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			if string(restored) != testCase.body {
+				t.Errorf("expected body to be restored as '%s', got '%s'", testCase.body, restored)
+			}
+			if r.GetBody == nil {
+				return
+			}
+			body, err := r.GetBody()
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			if again, _ := io.ReadAll(body); string(again) != testCase.body {
+				t.Errorf("expected body to be read again as '%s', got '%s'", testCase.body, again)
+			}
+		})
+	}
+}
+
+func isSearch(r *http.Request) bool {
+	return r.Method == http.MethodPost && r.URL.Path == "/search"
+}
+
+func TestMiddlewareMethods(t *testing.T) {
+	testCases := []struct {
+		name           string
+		options        []Option
+		path           string
+		cacheControl   string
+		bodies         []string
+		expectedCalled int
+	}{
+		{
+			name:           "post not cacheable by default",
+			bodies:         []string{`{"q":1}`, `{"q":1}`},
+			expectedCalled: 2,
+		},
+		{
+			name:           "same bodies",
+			options:        []Option{WithCacheableRequests(isSearch)},
+			bodies:         []string{`{"q":1}`, `{"q":1}`},
+			expectedCalled: 1,
+		},
+		{
+			name:           "other request",
+			options:        []Option{WithCacheableRequests(isSearch)},
+			path:           "/orders",
+			bodies:         []string{`{"q":1}`, `{"q":1}`},
+			expectedCalled: 2,
+		},
+		{
+			name:           "shared lifetime",
+			options:        []Option{WithCacheableRequests(isSearch)},
+			cacheControl:   "s-maxage=60",
+			bodies:         []string{`{"q":1}`, `{"q":1}`},
+			expectedCalled: 1,
+		},
+		{
+			name:           "no explicit lifetime",
+			options:        []Option{WithCacheableRequests(isSearch)},
+			cacheControl:   "public",
+			bodies:         []string{`{"q":1}`, `{"q":1}`},
+			expectedCalled: 2,
+		},
+		{
+			name:           "different bodies",
+			options:        []Option{WithCacheableRequests(isSearch)},
+			bodies:         []string{`{"q":1}`, `{"q":2}`},
+			expectedCalled: 2,
+		},
+		{
+			name:           "equivalent bodies with canonicalizer",
+			options:        []Option{WithCacheableRequests(isSearch), WithBodyCanonicalizer(CanonicalizeJSON)},
+			bodies:         []string{`{"q":1, "p":2}`, `{"p":2,"q":1}`},
+			expectedCalled: 1,
+		},
+		{
+			name:           "invalid bodies with canonicalizer",
+			options:        []Option{WithCacheableRequests(isSearch), WithBodyCanonicalizer(CanonicalizeJSON)},
+			bodies:         []string{`{"q":`, `{"q":`},
+			expectedCalled: 2,
+		},
+		{
+			name:           "bodies over limit",
+			options:        []Option{WithCacheableRequests(isSearch), WithMaxRequestBodySize(4)},
+			bodies:         []string{`{"q":1}`, `{"q":1}`},
+			expectedCalled: 2,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			called := 0
+			mw, err := NewMiddleware(&testStore{}, testCase.options...)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called++
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Fatal("unexpected error", err)
+				}
+				cacheControl := "max-age=60"
+				if testCase.cacheControl != "" {
+					cacheControl = testCase.cacheControl
+				}
+				w.Header().Set("Cache-Control", cacheControl)
+				_, _ = w.Write(body)
+			}))
+
+			path := "/search"
+			if testCase.path != "" {
+				path = testCase.path
+			}
+			for _, body := range testCase.bodies {
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+				if rr.Body.Len() == 0 {
+					t.Error("expected non-empty body")
+				}
+			}
+
+			if called != testCase.expectedCalled {
+				t.Errorf("expected handler to be called %d times, got %d", testCase.expectedCalled, called)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCacheableRequestsInvalidation(t *testing.T) {
+	called := 0
+	mw, err := NewMiddleware(&testStore{}, WithCacheableRequests(isSearch))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/orders", nil),
+		httptest.NewRequest(http.MethodPost, "/search", strings.NewReader("query")),
+		httptest.NewRequest(http.MethodGet, "/orders", nil),
+		httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("order")),
+		httptest.NewRequest(http.MethodGet, "/orders", nil),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	if called != 4 {
+		t.Errorf("expected handler to be called 4 times, got %d", called)
+	}
+	if _, err := NewMiddleware(&testStore{}, WithCacheableRequests(nil)); err == nil {
+		t.Error("expected error for nil function")
+	}
+}
@@ -0,0 +1,109 @@
+package httpcache
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDeltaSeconds is the greatest delta-seconds value we recognize,
+// larger values are capped to it (RFC 9111, section 1.2.2).
+const maxDeltaSeconds = 1<<31 - 1
+
+// cacheControl holds parsed Cache-Control directives. Directive names are
+// lower-cased, quoted values are unquoted.
+type cacheControl map[string]string
+
+func parseCacheControl(values []string) cacheControl {
+	cc := make(cacheControl)
+	for _, value := range values {
+		for _, directive := range splitDirectives(value) {
+			name, val := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, val = directive[:i], unquote(strings.TrimSpace(directive[i+1:]))
+			}
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, ok := cc[name]; ok { // first occurrence wins
+				continue
+			}
+			cc[name] = val
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// seconds returns directive's delta-seconds value. An invalid value is
+// reported as zero so that the response is treated as stale.
+func (cc cacheControl) seconds(directive string) (time.Duration, bool) {
+	val, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return maxDeltaSeconds * time.Second, true
+		}
+		return 0, true
+	}
+	if n > maxDeltaSeconds {
+		n = maxDeltaSeconds
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// splitDirectives splits a comma separated list, leaving commas inside
+// quoted strings intact.
+func splitDirectives(s string) []string {
+	var (
+		parts    []string
+		start    int
+		inQuotes bool
+		escaped  bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\' && inQuotes:
+			escaped = true
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == ',' && !inQuotes:
+			if part := strings.TrimSpace(s[start:i]); part != "" {
+				parts = append(parts, part)
+			}
+			start = i + 1
+		}
+	}
+	if part := strings.TrimSpace(s[start:]); part != "" {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package httpcache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseCacheControl(t *testing.T) {
+	testCases := []struct {
+		name     string
+		values   []string
+		expected cacheControl
+	}{
+		{
+			name:     "empty",
+			values:   nil,
+			expected: cacheControl{},
+		},
+		{
+			name:     "single directive",
+			values:   []string{"no-store"},
+			expected: cacheControl{"no-store": ""},
+		},
+		{
+			name:     "mixed case and spaces",
+			values:   []string{" Public ,  MAX-AGE=60 "},
+			expected: cacheControl{"public": "", "max-age": "60"},
+		},
+		{
+			name:     "quoted value with comma",
+			values:   []string{`private="Set-Cookie, X-Foo", max-age=5`},
+			expected: cacheControl{"private": "Set-Cookie, X-Foo", "max-age": "5"},
+		},
+		{
+			name:     "multiple header values",
+			values:   []string{"max-age=5", "s-maxage=10"},
+			expected: cacheControl{"max-age": "5", "s-maxage": "10"},
+		},
+		{
+			name:     "first occurrence wins",
+			values:   []string{"max-age=5, max-age=10"},
+			expected: cacheControl{"max-age": "5"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cc := parseCacheControl(testCase.values)
+			if !reflect.DeepEqual(testCase.expected, cc) {
+				t.Errorf("expected %v, got %v", testCase.expected, cc)
+			}
+		})
+	}
+}
+
+func Test_cacheControl_seconds(t *testing.T) {
+	testCases := []struct {
+		name       string
+		value      string
+		expected   time.Duration
+		expectedOk bool
+	}{
+		{name: "absent", value: "", expected: 0, expectedOk: false},
+		{name: "valid", value: "max-age=60", expected: time.Minute, expectedOk: true},
+		{name: "invalid", value: "max-age=foo", expected: 0, expectedOk: true},
+		{name: "negative", value: "max-age=-1", expected: 0, expectedOk: true},
+		{name: "overflow", value: "max-age=99999999999999999999", expected: maxDeltaSeconds * time.Second, expectedOk: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			d, ok := parseCacheControl([]string{testCase.value}).seconds("max-age")
+			if d != testCase.expected || ok != testCase.expectedOk {
+				t.Errorf("expected (%s, %v), got (%s, %v)", testCase.expected, testCase.expectedOk, d, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reasons for forwarding a request to the handler (RFC 9211, section 2.2).
+const (
+	fwdBypass   = "bypass"
+	fwdMethod   = "method"
+	fwdURIMiss  = "uri-miss"
+	fwdVaryMiss = "vary-miss"
+	fwdMiss     = "miss"
+	fwdRequest  = "request"
+	fwdStale    = "stale"
+)
+
+// CacheStatusFunc reports whether the Cache-Status header should be sent in
+// the response to the request.
+type CacheStatusFunc func(r *http.Request) bool
+
+// cacheStatus describes how the cache handled a request. It's sent to the
+// client in the Cache-Status header (RFC 9211).
+type cacheStatus struct {
+	hit       bool
+	fwd       string
+	fwdStatus int
+	ttl       time.Duration
+	hasTTL    bool
+	collapsed bool
+	key       string
+	detail    string
+
+	// fromCache is set when the response is served from the store although
+	// the request was forwarded, e.g. a stale response served on error.
+	fromCache bool
+}
+
+// format returns the Cache-Status list member of the cache with the name.
+func (cs cacheStatus) format(name string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	if cs.hit {
+		b.WriteString("; hit")
+	}
+	if cs.fwd != "" {
+		b.WriteString("; fwd=")
+		b.WriteString(cs.fwd)
+	}
+	if cs.fwdStatus != 0 {
+		b.WriteString("; fwd-status=")
+		b.WriteString(strconv.Itoa(cs.fwdStatus))
+	}
+	if cs.hasTTL {
+		b.WriteString("; ttl=")
+		b.WriteString(strconv.FormatInt(int64(cs.ttl/time.Second), 10))
+	}
+	if cs.collapsed {
+		b.WriteString("; collapsed")
+	}
+	if cs.key != "" {
+		b.WriteString("; key=")
+		b.WriteString(strconv.Quote(cs.key))
+	}
+	if cs.detail != "" {
+		b.WriteString("; detail=")
+		b.WriteString(cs.detail)
+	}
+	return b.String()
+}
+
+// setCacheStatus adds the Cache-Status member of the cache to the header of
+// the response to r, unless disabled for the request. Members added by
+// caches closer to the handler are kept in front of it.
+func (m middleware) setCacheStatus(h http.Header, r *http.Request, cs cacheStatus) {
+	if m.cacheStatus != nil && !m.cacheStatus(r) {
+		return
+	}
+	h.Add("Cache-Status", cs.format(m.cacheName))
+}
+
+// newRecorder returns a recorder passing the handler response through to
+// w, with the Cache-Status header added once the status is known and the
+// targeted cache control fields removed. Bodies of responses which can't be
+// stored aren't recorded.
+func (m middleware) newRecorder(w http.ResponseWriter, r *http.Request, cs cacheStatus) *httpResponseRecorder {
+	rec := newHttpResponseRecorder(w)
+	rec.maxBodySize = m.maxBodySize
+	rec.recordBody = m.mayStore
+	rec.beforeWriteHeader = func(h http.Header, statusCode int) {
+		cs.fwdStatus = statusCode
+		m.setCacheStatus(h, r, cs)
+		m.removeTargetedFields(h)
+	}
+	return rec
+}
+
+// validCacheName reports whether the name is a structured field token
+// (RFC 8941, section 3.3.4), as required for Cache-Status members.
+func validCacheName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', i == 0 && c == '*':
+		case i == 0:
+			return false
+		case c >= '0' && c <= '9', c == ':', c == '/', strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
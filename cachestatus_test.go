@@ -0,0 +1,201 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_cacheStatus_format(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   cacheStatus
+		expected string
+	}{
+		{name: "hit", status: cacheStatus{hit: true, ttl: 90 * time.Second, hasTTL: true, key: "1"},
+			expected: `httpcache; hit; ttl=90; key="1"`},
+		{name: "stale hit", status: cacheStatus{hit: true, ttl: -30 * time.Second, hasTTL: true},
+			expected: `httpcache; hit; ttl=-30`},
+		{name: "miss", status: cacheStatus{fwd: fwdURIMiss, fwdStatus: 200, key: "1"},
+			expected: `httpcache; fwd=uri-miss; fwd-status=200; key="1"`},
+		{name: "collapsed", status: cacheStatus{fwd: fwdURIMiss, collapsed: true},
+			expected: `httpcache; fwd=uri-miss; collapsed`},
+		{name: "detail", status: cacheStatus{fwd: fwdStale, fwdStatus: 500, detail: "stale-if-error"},
+			expected: `httpcache; fwd=stale; fwd-status=500; detail=stale-if-error`},
+		{name: "bypass", status: cacheStatus{fwd: fwdBypass},
+			expected: `httpcache; fwd=bypass`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := testCase.status.format("httpcache"); got != testCase.expected {
+				t.Errorf("expected '%s', got '%s'", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func Test_validCacheName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected bool
+	}{
+		{name: "httpcache", expected: true},
+		{name: "edge-1.example.com:8080/cache", expected: true},
+		{name: "*cache", expected: true},
+		{name: "", expected: false},
+		{name: "1cache", expected: false},
+		{name: "my cache", expected: false},
+		{name: "cache;hit", expected: false},
+		{name: `"cache"`, expected: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := validCacheName(testCase.name); got != testCase.expected {
+				t.Errorf("expected %v, got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCacheStatus(t *testing.T) {
+	mw, err := NewMiddleware(&testStore{}, WithCacheName("edge"), WithCacheableRequests(func(r *http.Request) bool { return r.Method == http.MethodPost }),
+		WithRequestCacheControl(func(r *http.Request) bool { return true }))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stale":
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/vary":
+			w.Header().Set("Vary", "Accept-Language")
+		default:
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	testCases := []struct {
+		name     string
+		request  *http.Request
+		expected string
+		age      bool
+	}{
+		{name: "uri miss", request: httptest.NewRequest(http.MethodGet, "/", nil),
+			expected: "edge; fwd=uri-miss; fwd-status=200; key="},
+		{name: "hit", request: httptest.NewRequest(http.MethodGet, "/", nil),
+			expected: "edge; hit; ttl=", age: true},
+		{name: "bypass", request: newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+			withHeader("X-Bypass-Cache", "1").build(),
+			expected: "edge; fwd=bypass"},
+		{name: "method", request: httptest.NewRequest(http.MethodDelete, "/", nil),
+			expected: "edge; fwd=method"},
+		{name: "request body", request: httptest.NewRequest(http.MethodPost, "/",
+			strings.NewReader(strings.Repeat("a", 2<<20))),
+			expected: "edge; fwd=bypass; fwd-status=200; detail=request-body"},
+		{name: "stale fill", request: httptest.NewRequest(http.MethodGet, "/stale", nil),
+			expected: "edge; fwd=uri-miss; fwd-status=200; key="},
+		{name: "stale", request: httptest.NewRequest(http.MethodGet, "/stale", nil),
+			expected: "edge; fwd=stale; fwd-status=304; ttl="},
+		{name: "vary fill", request: newRequestBuilder().withMethod(http.MethodGet).withPath("/vary").
+			withHeader("Accept-Language", "en").build(),
+			expected: "edge; fwd=uri-miss; fwd-status=200; key="},
+		{name: "vary miss", request: newRequestBuilder().withMethod(http.MethodGet).withPath("/vary").
+			withHeader("Accept-Language", "de").build(),
+			expected: "edge; fwd=vary-miss; fwd-status=200; key="},
+		{name: "only if cached", request: newRequestBuilder().withMethod(http.MethodGet).withPath("/missing").
+			withHeader("Cache-Control", "only-if-cached").build(),
+			expected: "edge; fwd=uri-miss; key="},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, testCase.request)
+
+			if status := rr.Header().Get("Cache-Status"); !strings.HasPrefix(status, testCase.expected) {
+				t.Errorf("expected Cache-Status to start with '%s', got '%s'", testCase.expected, status)
+			}
+			if hasAge := rr.Header().Get("Age") != ""; hasAge != testCase.age {
+				t.Errorf("expected Age header presence to be %v", testCase.age)
+			}
+		})
+	}
+}
+
+func TestMiddlewareAge(t *testing.T) {
+	mw, err := NewMiddleware(&testStore{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=600")
+		w.Header().Set("Age", "100")
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	age, err := strconv.Atoi(rr.Header().Get("Age"))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if age < 100 || age > 101 {
+		t.Errorf("expected Age to be 100, got %d", age)
+	}
+	if status := rr.Header().Get("Cache-Status"); !strings.HasPrefix(status, "httpcache; hit; ttl=49") {
+		t.Errorf("expected Cache-Status to report remaining freshness, got '%s'", status)
+	}
+}
+
+func TestMiddlewareCacheStatusDisabled(t *testing.T) {
+	mw, err := NewMiddleware(&testStore{}, WithCacheStatus(func(r *http.Request) bool {
+		return r.Header.Get("X-Debug") != ""
+	}))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if status := rr.Header().Get("Cache-Status"); status != "" {
+		t.Errorf("expected no Cache-Status, got '%s'", status)
+	}
+	if rr.Header().Get("Age") == "" {
+		t.Error("expected Age header")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+		withHeader("X-Debug", "1").build())
+	if status := rr.Header().Get("Cache-Status"); !strings.HasPrefix(status, "httpcache; hit") {
+		t.Errorf("expected Cache-Status to report a hit, got '%s'", status)
+	}
+}
+
+func TestCacheStatusOptions(t *testing.T) {
+	if _, err := NewMiddleware(&testStore{}, WithCacheName("my cache")); err == nil {
+		t.Error("expected error for invalid cache name")
+	}
+	if _, err := NewMiddleware(&testStore{}, WithCacheStatus(nil)); err == nil {
+		t.Error("expected error for nil function")
+	}
+}
@@ -0,0 +1,127 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// fillGroup collapses concurrent cache fills of the same key, so that only
+// one request runs the handler while others wait for its response.
+type fillGroup struct {
+	mutex sync.Mutex
+	calls map[uint64]*fillCall
+}
+
+type fillCall struct {
+	done chan struct{}
+
+	// set before done is closed
+	req http.Header
+	res cachedResponse
+	ok  bool
+}
+
+func newFillGroup() *fillGroup {
+	return &fillGroup{calls: make(map[uint64]*fillCall)}
+}
+
+// join returns the fill of key in flight, or starts a new one. It reports
+// whether the caller is the leader, which must call leave when done.
+func (g *fillGroup) join(key uint64) (*fillCall, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if c, ok := g.calls[key]; ok {
+		return c, false
+	}
+	c := &fillCall{done: make(chan struct{})}
+	g.calls[key] = c
+	return c, true
+}
+
+// leave publishes the response of the fill to waiters. ok reports whether
+// the response is cacheable and may be shared.
+func (g *fillGroup) leave(key uint64, c *fillCall, req http.Header, res cachedResponse, ok bool) {
+	g.mutex.Lock()
+	delete(g.calls, key)
+	g.mutex.Unlock()
+
+	c.req, c.res, c.ok = req, res, ok
+	close(c.done)
+}
+
+// wait waits for the fill to finish and returns its response, if it may be
+// shared.
+func (c *fillCall) wait(ctx context.Context, timeout time.Duration) (cachedResponse, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.done:
+		return c.res, c.ok
+	case <-timer.C:
+		return cachedResponse{}, false
+	case <-ctx.Done():
+		return cachedResponse{}, false
+	}
+}
+
+// fill runs the handler, passing its response through and storing it, or
+// refreshes the stale response. With request coalescing enabled, concurrent
+// fills of the same key wait for the first one and are served its response. Waiters fall back to running the
+// handler themselves if it takes too long or the response isn't cacheable.
+func (m middleware) fill(w http.ResponseWriter, r *http.Request, key uint64, reqCC cacheControl, stale *cachedResponse, cs cacheStatus) {
+	if m.fills == nil {
+		m.fillLocked(w, r, key, reqCC, stale, cs)
+		return
+	}
+
+	c, leader := m.fills.join(key)
+	if !leader {
+		if res, ok := c.wait(r.Context(), m.coalescingTimeout); ok && m.sameVariant(key, res, c.req, r.Header) {
+			cs.collapsed, cs.fromCache = true, true
+			m.serveCachedResponse(w, r, res, cs)
+			return
+		}
+		m.fillLocked(w, r, key, reqCC, stale, cs)
+		return
+	}
+
+	var (
+		res cachedResponse
+		ok  bool
+	)
+	defer func() {
+		m.fills.leave(key, c, r.Header, res, ok)
+	}()
+	res, ok = m.fillLocked(w, r, key, reqCC, stale, cs)
+}
+
+// sameVariant reports whether the response to a request with header a may
+// be used for a request with header b.
+func (m middleware) sameVariant(key uint64, res cachedResponse, a, b http.Header) bool {
+	fields := varyFields(res.Header)
+	return len(fields) == 0 || m.secondaryKey(key, fields, a) == m.secondaryKey(key, fields, b)
+}
+
+// serveAndStore runs the handler and stores its response. A stale response
+// is refreshed instead: served if the handler fails and stale-if-error
+// allows it, or revalidated if it has validators. It returns the stored
+// response and whether it's cacheable.
+func (m middleware) serveAndStore(w http.ResponseWriter, r *http.Request, key uint64, reqCC cacheControl, stale *cachedResponse, cs cacheStatus) (cachedResponse, bool) {
+	switch {
+	case stale != nil && stale.staleIfError(reqCC, time.Now(), m.staleIfError):
+		return m.refreshOrServeStale(w, r, key, reqCC, *stale, cs)
+	case stale != nil && stale.hasValidators():
+		return m.revalidate(w, r, key, reqCC, *stale, cs)
+	case isRangeRequest(r):
+		return m.fillRange(w, r, key, reqCC, cs)
+	}
+
+	rec := m.newRecorder(w, r, cs)
+	m.serve(rec, r)
+
+	return m.saveRecorded(r, key, reqCC, rec)
+}
@@ -0,0 +1,198 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareRequestCoalescing(t *testing.T) {
+	testCases := []struct {
+		name           string
+		cacheControl   string
+		expectedCalled int32
+	}{
+		{name: "cacheable", cacheControl: "max-age=60", expectedCalled: 1},
+		{name: "uncacheable", cacheControl: "no-store", expectedCalled: 5},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			const requests = 5
+			var called int32
+			release := make(chan struct{})
+			store := &testStore{}
+			mw, err := NewMiddleware(store, WithRequestCoalescing(time.Second))
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&called, 1) == 1 {
+					<-release
+				}
+				w.Header().Set("Cache-Control", testCase.cacheControl)
+				_, _ = w.Write([]byte("hello"))
+			}))
+
+			var wg sync.WaitGroup
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rr := httptest.NewRecorder()
+					handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+					if body := rr.Body.String(); body != "hello" {
+						t.Errorf("expected body to be 'hello', got '%s'", body)
+					}
+				}()
+			}
+
+			for {
+				store.mutex.Lock()
+				getCalled := store.getCalled
+				store.mutex.Unlock()
+				if getCalled == requests {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(10 * time.Millisecond) // let waiters join the fill
+			close(release)
+			wg.Wait()
+
+			if c := atomic.LoadInt32(&called); c != testCase.expectedCalled {
+				t.Errorf("expected handler to be called %d times, got %d", testCase.expectedCalled, c)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRequestCoalescingStale(t *testing.T) {
+	testCases := []struct {
+		name         string
+		options      []Option
+		cacheControl string
+		modified     bool
+	}{
+		{name: "not modified", cacheControl: "max-age=0"},
+		{name: "modified", cacheControl: "max-age=0", modified: true},
+		{name: "stale-if-error", cacheControl: "max-age=0, stale-if-error=60"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			const requests = 20
+			var called int32
+			release := make(chan struct{})
+			store := &testLockingStore{}
+			options := append([]Option{WithRequestCoalescing(time.Second)}, testCase.options...)
+			mw, err := NewMiddleware(store, options...)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				etag := `"v1"`
+				if atomic.AddInt32(&called, 1) == 2 {
+					<-release
+					if testCase.modified {
+						etag = `"v2"`
+					}
+				}
+				w.Header().Set("Cache-Control", testCase.cacheControl)
+				w.Header().Set("ETag", etag)
+				if r.Header.Get("If-None-Match") == etag {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				_, _ = w.Write([]byte("hello"))
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			var wg sync.WaitGroup
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rr := httptest.NewRecorder()
+					handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+					if body := rr.Body.String(); rr.Code != http.StatusOK || body != "hello" {
+						t.Errorf("expected body to be 'hello', got %d '%s'", rr.Code, body)
+					}
+				}()
+			}
+
+			for {
+				store.mutex.Lock()
+				getCalled := store.getCalled
+				store.mutex.Unlock()
+				if getCalled >= requests+1 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(10 * time.Millisecond) // let waiters join the fill
+			close(release)
+			wg.Wait()
+
+			if c := atomic.LoadInt32(&called); c != 2 {
+				t.Errorf("expected handler to be called 2 times, got %d", c)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRequestCoalescingTimeout(t *testing.T) {
+	var called int32
+	release := make(chan struct{})
+	mw, err := NewMiddleware(&testStore{}, WithRequestCoalescing(time.Millisecond))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&called, 1) == 1 {
+			<-release
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	for atomic.LoadInt32(&called) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	close(release)
+	<-done
+
+	if body := rr.Body.String(); body != "hello" {
+		t.Errorf("expected body to be 'hello', got '%s'", body)
+	}
+	if c := atomic.LoadInt32(&called); c != 2 {
+		t.Errorf("expected handler to be called 2 times, got %d", c)
+	}
+}
+
+func Test_middleware_sameVariant(t *testing.T) {
+	mw, err := NewMiddleware(&testStore{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	m := mw(nil).(*middleware)
+	res := cachedResponse{Header: http.Header{"Vary": {"Accept-Language"}}}
+
+	if !m.sameVariant(1, res, http.Header{"Accept-Language": {"en"}}, http.Header{"Accept-Language": {"en"}}) {
+		t.Error("expected same variant")
+	}
+	if m.sameVariant(1, res, http.Header{"Accept-Language": {"en"}}, http.Header{"Accept-Language": {"de"}}) {
+		t.Error("expected different variants")
+	}
+}
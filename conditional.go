@@ -0,0 +1,115 @@
+package httpcache
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notModifiedHeaders are the stored header fields sent in a 304 response
+// (RFC 9110, section 15.4.5).
+var notModifiedHeaders = []string{
+	"Cache-Control",
+	"Content-Location",
+	"Date",
+	"ETag",
+	"Expires",
+	"Vary",
+}
+
+// evaluatePreconditions evaluates conditional request header fields against
+// a stored response with the given headers, following the precedence defined
+// in RFC 9110, section 13.2.2. It returns either http.StatusNotModified,
+// http.StatusPreconditionFailed or 0 if the response should be served in full.
+func evaluatePreconditions(r *http.Request, h http.Header) int {
+	etag := h.Get("ETag")
+	lastModified, lastModifiedErr := http.ParseTime(h.Get("Last-Modified"))
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagListMatch(ifMatch, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && lastModifiedErr == nil {
+		if modifiedSince(lastModified, ius) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagListMatch(ifNoneMatch, etag, true) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err == nil && lastModifiedErr == nil && !modifiedSince(lastModified, ims) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// modifiedSince compares dates with a second precision, as HTTP dates have.
+func modifiedSince(lastModified, since time.Time) bool {
+	return lastModified.Truncate(time.Second).After(since)
+}
+
+// etagListMatch reports whether etag matches any entity tag in the list,
+// using weak or strong comparison (RFC 9110, section 8.8.3.2).
+func etagListMatch(list string, etag string, weak bool) bool {
+	list = strings.TrimSpace(list)
+	if list == "*" {
+		return true
+	}
+	if etag == "" {
+		return false
+	}
+
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return false
+		}
+		tag, rest := scanETag(list)
+		if tag == "" {
+			return false
+		}
+		if etagMatch(tag, etag, weak) {
+			return true
+		}
+		list = rest
+	}
+}
+
+func etagMatch(a, b string, weak bool) bool {
+	if weak {
+		return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+	}
+	return a == b && !strings.HasPrefix(a, "W/")
+}
+
+// scanETag returns the entity tag at the beginning of s and the remainder,
+// or an empty tag if s doesn't start with a valid entity tag.
+func scanETag(s string) (string, string) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s)-start < 2 || s[start] != '"' {
+		return "", ""
+	}
+	for i := start + 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return s[:i+1], s[i+1:]
+		case c == 0x21 || c >= 0x23 && c != 0x7f: // etagc
+		default:
+			return "", ""
+		}
+	}
+	return "", ""
+}
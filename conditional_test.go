@@ -0,0 +1,83 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_evaluatePreconditions(t *testing.T) {
+	lastModified := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	header := http.Header{
+		"Etag":          {`"abc"`},
+		"Last-Modified": {lastModified.Format(http.TimeFormat)},
+	}
+	before := lastModified.Add(-time.Hour).Format(http.TimeFormat)
+	after := lastModified.Add(time.Hour).Format(http.TimeFormat)
+
+	testCases := []struct {
+		name     string
+		method   string
+		header   http.Header
+		expected int
+	}{
+		{name: "no conditions", header: http.Header{}, expected: 0},
+		{name: "if-none-match match", header: http.Header{"If-None-Match": {`"abc"`}}, expected: http.StatusNotModified},
+		{name: "if-none-match weak match", header: http.Header{"If-None-Match": {`W/"abc"`}}, expected: http.StatusNotModified},
+		{name: "if-none-match list", header: http.Header{"If-None-Match": {`"xyz", "abc"`}}, expected: http.StatusNotModified},
+		{name: "if-none-match asterisk", header: http.Header{"If-None-Match": {"*"}}, expected: http.StatusNotModified},
+		{name: "if-none-match mismatch", header: http.Header{"If-None-Match": {`"xyz"`}}, expected: 0},
+		{name: "if-none-match on post", method: http.MethodPost, header: http.Header{"If-None-Match": {`"abc"`}}, expected: http.StatusPreconditionFailed},
+		{name: "if-modified-since not modified", header: http.Header{"If-Modified-Since": {after}}, expected: http.StatusNotModified},
+		{name: "if-modified-since modified", header: http.Header{"If-Modified-Since": {before}}, expected: 0},
+		{
+			name: "if-none-match takes precedence over if-modified-since",
+			header: http.Header{
+				"If-None-Match":     {`"xyz"`},
+				"If-Modified-Since": {after},
+			},
+			expected: 0,
+		},
+		{name: "if-match match", header: http.Header{"If-Match": {`"abc"`}}, expected: 0},
+		{name: "if-match weak tag", header: http.Header{"If-Match": {`W/"abc"`}}, expected: http.StatusPreconditionFailed},
+		{name: "if-unmodified-since modified", header: http.Header{"If-Unmodified-Since": {before}}, expected: http.StatusPreconditionFailed},
+		{name: "if-unmodified-since not modified", header: http.Header{"If-Unmodified-Since": {after}}, expected: 0},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			method := testCase.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/", nil)
+			r.Header = testCase.header
+			if status := evaluatePreconditions(r, header); status != testCase.expected {
+				t.Errorf("expected %d, got %d", testCase.expected, status)
+			}
+		})
+	}
+}
+
+func Test_scanETag(t *testing.T) {
+	testCases := []struct {
+		s            string
+		expectedTag  string
+		expectedRest string
+	}{
+		{s: `"abc"`, expectedTag: `"abc"`, expectedRest: ""},
+		{s: `W/"abc", "xyz"`, expectedTag: `W/"abc"`, expectedRest: `, "xyz"`},
+		{s: `"a,b"`, expectedTag: `"a,b"`, expectedRest: ""},
+		{s: `abc`, expectedTag: "", expectedRest: ""},
+		{s: `"abc`, expectedTag: "", expectedRest: ""},
+	}
+
+	for _, testCase := range testCases {
+		tag, rest := scanETag(testCase.s)
+		if tag != testCase.expectedTag || rest != testCase.expectedRest {
+			t.Errorf("%s: expected (%s, %s), got (%s, %s)",
+				testCase.s, testCase.expectedTag, testCase.expectedRest, tag, rest)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// minCompressSize is the size of the smallest body compressed for storage,
+// smaller ones hardly get any smaller.
+const minCompressSize = 256
+
+// compress returns the response with the body compressed with gzip, if
+// enabled and the handler didn't encode it already. Responses the handler
+// asked not to transform, or which don't get smaller, are returned as is.
+func (m middleware) compress(res cachedResponse) cachedResponse {
+	if m.compressionLevel == 0 || res.Encoding != "" || len(res.Body) < minCompressSize {
+		return res
+	}
+	if ce := res.Header.Get("Content-Encoding"); ce != "" && !strings.EqualFold(ce, "identity") {
+		return res
+	}
+	if _, ok := parseCacheControl(res.Header.Values("Cache-Control"))["no-transform"]; ok {
+		return res
+	}
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, m.compressionLevel)
+	if err != nil {
+		m.onError(fmt.Errorf("failed to compress: %v", err))
+		return res
+	}
+	_, _ = zw.Write(res.Body) // writes to a bytes.Buffer don't fail
+	if err := zw.Close(); err != nil {
+		m.onError(fmt.Errorf("failed to compress: %v", err))
+		return res
+	}
+	if buf.Len() >= len(res.Body) {
+		return res
+	}
+
+	res.Body, res.Encoding = buf.Bytes(), "gzip"
+	return res
+}
+
+// selectEncoding returns the stored response as served to r. A compressed
+// body is served as is to clients accepting the encoding, with a weak ETag
+// as the representation differs from the one of the handler, and decoded
+// for others and for range requests.
+func (m middleware) selectEncoding(r *http.Request, cr cachedResponse) (cachedResponse, error) {
+	if cr.Encoding == "" {
+		return cr, nil
+	}
+
+	header := cr.Header.Clone()
+	if !varyOn(header, "Accept-Encoding") {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	cr.Header = header
+
+	if !isRangeRequest(r) && acceptsEncoding(r, cr.Encoding) {
+		header.Set("Content-Encoding", cr.Encoding)
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		return cr, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(cr.Body))
+	if err != nil {
+		return cr, fmt.Errorf("failed to decompress: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		return cr, fmt.Errorf("failed to decompress: %v", err)
+	}
+	cr.Body, cr.Encoding = body, ""
+	return cr, nil
+}
+
+// varyOn reports whether the response with the header varies on the
+// request field.
+func varyOn(h http.Header, field string) bool {
+	for _, f := range varyFields(h) {
+		if f == "*" || f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header of r allows the
+// content coding (RFC 9110, section 12.5.3).
+func acceptsEncoding(r *http.Request, coding string) bool {
+	accepted, wildcard := false, false
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, member := range strings.Split(value, ",") {
+			name, params, _ := cut(member, ";")
+			name = strings.TrimSpace(name)
+			q := 1.0
+			if k, v, ok := cut(params, "="); ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				var err error
+				if q, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+					q = 0
+				}
+			}
+
+			switch {
+			case strings.EqualFold(name, coding) || coding == "gzip" && strings.EqualFold(name, "x-gzip"):
+				if q == 0 {
+					return false
+				}
+				accepted = true
+			case name == "*":
+				wildcard = q > 0
+			}
+		}
+	}
+	return accepted || wildcard
+}
+
+// cut slices s around the first instance of sep, as strings.Cut does.
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,214 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_acceptsEncoding(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   string
+		expected bool
+	}{
+		{name: "none", header: "", expected: false},
+		{name: "gzip", header: "gzip, deflate, br", expected: true},
+		{name: "case", header: "GZIP", expected: true},
+		{name: "x-gzip", header: "x-gzip", expected: true},
+		{name: "quality", header: "br;q=1.0, gzip;q=0.5", expected: true},
+		{name: "refused", header: "gzip;q=0, *", expected: false},
+		{name: "wildcard", header: "br, *;q=0.1", expected: true},
+		{name: "refused wildcard", header: "br, *;q=0", expected: false},
+		{name: "identity", header: "identity", expected: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+				withHeader("Accept-Encoding", testCase.header).build()
+			if got := acceptsEncoding(r, "gzip"); got != testCase.expected {
+				t.Errorf("expected %v, got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCompression(t *testing.T) {
+	body := strings.Repeat("hello world ", 100)
+
+	store := &testStore{}
+	mw, err := NewMiddleware(store, WithCompression(gzip.BestSpeed))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		switch r.URL.Path {
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "br")
+		case "/no-transform":
+			w.Header().Set("Cache-Control", "max-age=60, no-transform")
+		case "/small":
+			_, _ = w.Write([]byte("hello"))
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+		withHeader("Accept-Encoding", "gzip").build())
+	if rr.Body.String() != body || rr.Header().Get("Content-Encoding") != "" {
+		t.Error("expected the response of the handler to be served as is")
+	}
+	for _, data := range store.data {
+		if len(data) >= len(body) {
+			t.Errorf("expected body to be stored compressed, got %d bytes", len(data))
+		}
+	}
+
+	t.Run("accepted", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+			withHeader("Accept-Encoding", "gzip").build())
+
+		if ce := rr.Header().Get("Content-Encoding"); ce != "gzip" {
+			t.Errorf("expected Content-Encoding 'gzip', got '%s'", ce)
+		}
+		if vary := rr.Header().Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("expected Vary 'Accept-Encoding', got '%s'", vary)
+		}
+		if etag := rr.Header().Get("ETag"); etag != `W/"v1"` {
+			t.Errorf(`expected ETag 'W/"v1"', got '%s'`, etag)
+		}
+		if cl := rr.Header().Get("Content-Length"); cl != strconv.Itoa(rr.Body.Len()) {
+			t.Errorf("expected Content-Length %d, got '%s'", rr.Body.Len(), cl)
+		}
+		zr, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if decoded, _ := io.ReadAll(zr); string(decoded) != body {
+			t.Errorf("expected compressed body to decode to '%s', got '%s'", body, string(decoded))
+		}
+	})
+
+	t.Run("not accepted", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if ce := rr.Header().Get("Content-Encoding"); ce != "" {
+			t.Errorf("expected no Content-Encoding, got '%s'", ce)
+		}
+		if vary := rr.Header().Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("expected Vary 'Accept-Encoding', got '%s'", vary)
+		}
+		if etag := rr.Header().Get("ETag"); etag != `"v1"` {
+			t.Errorf(`expected ETag '"v1"', got '%s'`, etag)
+		}
+		if rr.Body.String() != body {
+			t.Errorf("expected decompressed body, got '%s'", rr.Body.String())
+		}
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+			withHeader("Accept-Encoding", "gzip").withHeader("If-None-Match", `W/"v1"`).build())
+
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("expected status %d, got %d", http.StatusNotModified, rr.Code)
+		}
+		if etag := rr.Header().Get("ETag"); etag != `W/"v1"` {
+			t.Errorf(`expected ETag 'W/"v1"', got '%s'`, etag)
+		}
+	})
+
+	t.Run("if-match", func(t *testing.T) {
+		for _, tag := range []string{`"v1"`, `*`} {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+				withHeader("Accept-Encoding", "gzip").withHeader("If-Match", tag).build())
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("expected status %d for If-Match %s, got %d", http.StatusOK, tag, rr.Code)
+			}
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+			withHeader("Accept-Encoding", "gzip").withHeader("If-Match", `"v0"`).build())
+		if rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("expected status %d, got %d", http.StatusPreconditionFailed, rr.Code)
+		}
+	})
+
+	t.Run("range", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+			withHeader("Accept-Encoding", "gzip").withHeader("Range", "bytes=0-4").build())
+
+		if rr.Code != http.StatusPartialContent || rr.Body.String() != "hello" {
+			t.Errorf("expected range of the decompressed body, got %d '%s'", rr.Code, rr.Body.String())
+		}
+		if ce := rr.Header().Get("Content-Encoding"); ce != "" {
+			t.Errorf("expected no Content-Encoding, got '%s'", ce)
+		}
+	})
+
+	for _, path := range []string{"/encoded", "/no-transform", "/small"} {
+		t.Run("uncompressed "+path, func(t *testing.T) {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath(path).
+				withHeader("Accept-Encoding", "gzip").build())
+
+			if rr.Header().Get("Age") == "" {
+				t.Error("expected response to be served from the cache")
+			}
+			if vary := rr.Header().Get("Vary"); vary != "" {
+				t.Errorf("expected no Vary, got '%s'", vary)
+			}
+			if etag := rr.Header().Get("ETag"); etag != `"v1"` {
+				t.Errorf(`expected ETag '"v1"', got '%s'`, etag)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCompressionVary(t *testing.T) {
+	mw, err := NewMiddleware(&testStore{}, WithCompression(gzip.DefaultCompression))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "accept-encoding")
+		_, _ = w.Write(bytes.Repeat([]byte("a"), 1024))
+	}))
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if vary := rr.Header().Values("Vary"); len(vary) != 1 {
+			t.Errorf("expected Vary not to be repeated, got %v", vary)
+		}
+	}
+}
+
+func TestCompressionOptions(t *testing.T) {
+	for _, level := range []int{gzip.NoCompression, gzip.HuffmanOnly, 10} {
+		if _, err := NewMiddleware(&testStore{}, WithCompression(level)); err == nil {
+			t.Errorf("expected error for level %d", level)
+		}
+	}
+}
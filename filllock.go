@@ -0,0 +1,80 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// fillLocked runs the handler and stores its response, or refreshes the stale
+// response, while holding the fill lock of key, if enabled. If another
+// instance holds the lock, the stale response is served when allowed, or the
+// store is polled for the response being filled. It returns the response and
+// whether it's cacheable.
+func (m middleware) fillLocked(w http.ResponseWriter, r *http.Request, key uint64, reqCC cacheControl, stale *cachedResponse, cs cacheStatus) (cachedResponse, bool) {
+	if m.locker == nil {
+		return m.serveAndStore(w, r, key, reqCC, stale, cs)
+	}
+
+	token, acquired, err := m.locker.Lock(r.Context(), key, m.fillLockTTL)
+	if err != nil {
+		m.onError(fmt.Errorf("failed to acquire fill lock: %v", err))
+		return m.serveAndStore(w, r, key, reqCC, stale, cs)
+	}
+	if acquired {
+		defer func() {
+			if err := m.locker.Unlock(detachedContext{r.Context()}, key, token); err != nil {
+				m.onError(fmt.Errorf("failed to release fill lock: %v", err))
+			}
+		}()
+		return m.serveAndStore(w, r, key, reqCC, stale, cs)
+	}
+
+	if stale != nil {
+		if _, ok := stale.allowsStale(reqCC); ok {
+			m.serveCachedResponse(w, r, stale.withWarning(`110 - "Response is Stale"`), cacheStatus{hit: true, key: cs.key, detail: "fill-locked"})
+			return cachedResponse{}, false
+		}
+	}
+
+	if cr, ok := m.awaitFill(r, key, reqCC); ok {
+		m.serveCachedResponse(w, r, cr, cacheStatus{hit: true, collapsed: true, key: cs.key})
+		return cr, true
+	}
+	return m.serveAndStore(w, r, key, reqCC, stale, cs)
+}
+
+// minFillPollInterval bounds the interval the store is polled at while
+// waiting for a fill.
+const minFillPollInterval = time.Millisecond
+
+// awaitFill polls the store until a response satisfying the request appears
+// or the wait time is exceeded.
+func (m middleware) awaitFill(r *http.Request, key uint64, reqCC cacheControl) (cachedResponse, bool) {
+	interval := m.fillLockWait / 10
+	if interval < minFillPollInterval {
+		interval = minFillPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	timer := time.NewTimer(m.fillLockWait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cr, err := m.lookup(r.Context(), key, r)
+			if err == nil && cr.satisfies(reqCC, time.Now()) {
+				return cr, true
+			}
+			if err != nil && err != ErrNoEntry && err != errNoVariant {
+				m.onError(err)
+				return cachedResponse{}, false
+			}
+		case <-timer.C:
+			return cachedResponse{}, false
+		case <-r.Context().Done():
+			return cachedResponse{}, false
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testLockingStore struct {
+	testStore
+
+	locks   map[uint64]string
+	lockSeq int
+}
+
+func (s *testLockingStore) Lock(_ context.Context, key uint64, _ time.Duration) (string, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.locks == nil {
+		s.locks = make(map[uint64]string)
+	}
+	if _, ok := s.locks[key]; ok {
+		return "", false, nil
+	}
+	s.lockSeq++
+	s.locks[key] = strconv.Itoa(s.lockSeq)
+	return s.locks[key], true, nil
+}
+
+func (s *testLockingStore) Unlock(_ context.Context, key uint64, token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.locks[key] == token {
+		delete(s.locks, key)
+	}
+	return nil
+}
+
+func TestMiddlewareFillLock(t *testing.T) {
+	newHandler := func(t *testing.T, store Store, body string, called *int32, opts ...Option) http.Handler {
+		mw, err := NewMiddleware(store, opts...)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		return mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(called, 1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = w.Write([]byte(body))
+		}))
+	}
+
+	t.Run("lock acquired", func(t *testing.T) {
+		var called int32
+		store := &testLockingStore{}
+		handler := newHandler(t, store, "a", &called, WithFillLock(time.Minute, time.Second))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if body := rr.Body.String(); body != "a" || called != 1 {
+			t.Errorf("expected handler to be called once with body 'a', got %d and '%s'", called, body)
+		}
+		if len(store.locks) != 0 {
+			t.Error("expected lock to be released")
+		}
+	})
+
+	t.Run("filled by another instance", func(t *testing.T) {
+		var calledA, calledB int32
+		store := &testLockingStore{}
+		handlerA := newHandler(t, store, "a", &calledA, WithFillLock(time.Minute, time.Second))
+		handlerB := newHandler(t, store, "b", &calledB)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		token, _, _ := store.Lock(context.Background(), handlerA.(*middleware).generateKey(req.URL), time.Minute)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			handlerB.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+
+		rr := httptest.NewRecorder()
+		handlerA.ServeHTTP(rr, req)
+
+		if body := rr.Body.String(); body != "b" {
+			t.Errorf("expected body 'b', got '%s'", body)
+		}
+		if c := atomic.LoadInt32(&calledA); c != 0 {
+			t.Errorf("expected handler not to be called, got %d", c)
+		}
+		_ = store.Unlock(context.Background(), handlerA.(*middleware).generateKey(req.URL), token)
+	})
+
+	t.Run("wait timeout", func(t *testing.T) {
+		var called int32
+		store := &testLockingStore{}
+		handler := newHandler(t, store, "a", &called, WithFillLock(time.Minute, 20*time.Millisecond))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		_, _, _ = store.Lock(context.Background(), handler.(*middleware).generateKey(req.URL), time.Minute)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if body := rr.Body.String(); body != "a" || called != 1 {
+			t.Errorf("expected handler to be called once with body 'a', got %d and '%s'", called, body)
+		}
+	})
+
+	newRevalidatingHandler := func(t *testing.T, store Store, cacheControl func(call int32) string, called *int32, opts ...Option) http.Handler {
+		mw, err := NewMiddleware(store, opts...)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		return mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", cacheControl(atomic.AddInt32(called, 1)))
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			_, _ = w.Write([]byte("stale"))
+		}))
+	}
+
+	t.Run("stale served", func(t *testing.T) {
+		var called int32
+		store := &testLockingStore{}
+		handler := newRevalidatingHandler(t, store, func(int32) string { return "max-age=0" }, &called,
+			WithFillLock(time.Minute, time.Second))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		_, _, _ = store.Lock(context.Background(), handler.(*middleware).generateKey(req.URL), time.Minute)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if body := rr.Body.String(); body != "stale" || called != 1 {
+			t.Errorf("expected stale body without revalidating, got %d and '%s'", called, body)
+		}
+		if rr.Header().Get("Warning") == "" {
+			t.Error("expected Warning header")
+		}
+	})
+
+	t.Run("revalidated by another instance", func(t *testing.T) {
+		var calledA, calledB int32
+		store := &testLockingStore{}
+		cacheControl := func(call int32) string {
+			if call == 1 {
+				return "max-age=0, must-revalidate"
+			}
+			return "max-age=60"
+		}
+		handlerA := newRevalidatingHandler(t, store, cacheControl, &calledA, WithFillLock(time.Minute, time.Second))
+		handlerB := newRevalidatingHandler(t, store, cacheControl, &calledB)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		handlerB.ServeHTTP(httptest.NewRecorder(), req)
+		token, _, _ := store.Lock(context.Background(), handlerA.(*middleware).generateKey(req.URL), time.Minute)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			handlerB.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+
+		rr := httptest.NewRecorder()
+		handlerA.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if body := rr.Body.String(); body != "stale" || rr.Header().Get("Warning") != "" {
+			t.Errorf("expected revalidated body without Warning, got '%s'", body)
+		}
+		if c := atomic.LoadInt32(&calledA); c != 0 {
+			t.Errorf("expected handler not to be called, got %d", c)
+		}
+		if c := atomic.LoadInt32(&calledB); c != 2 {
+			t.Errorf("expected response to be revalidated once, got %d", c)
+		}
+		_ = store.Unlock(context.Background(), handlerA.(*middleware).generateKey(req.URL), token)
+	})
+}
+
+func TestNewMiddlewareFillLockRequiresLocker(t *testing.T) {
+	if _, err := NewMiddleware(&testStore{}, WithFillLock(time.Minute, time.Second)); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func Test_awaitFillShortWait(t *testing.T) {
+	m := middleware{store: &testStore{}, fillLockWait: 5 * time.Nanosecond, onError: noopOnErrorFunc}
+
+	if _, ok := m.awaitFill(httptest.NewRequest(http.MethodGet, "/", nil), 1, nil); ok {
+		t.Error("expected no response to be found")
+	}
+}
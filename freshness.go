@@ -0,0 +1,64 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// freshnessLifetime computes the freshness lifetime of a response generated
+// at date as described in RFC 9111, section 4.2.1. It returns false when the
+// response has neither explicit nor heuristic expiration.
+func freshnessLifetime(h http.Header, cc cacheControl, date time.Time, heuristicFraction float64) (time.Duration, bool) {
+	if lifetime, ok := cc.seconds("s-maxage"); ok {
+		return lifetime, true
+	}
+	if lifetime, ok := cc.seconds("max-age"); ok {
+		return lifetime, true
+	}
+
+	if expires := h.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil { // invalid dates, e.g. "0", represent a time in the past
+			return 0, true
+		}
+		if lifetime := t.Sub(date); lifetime > 0 {
+			return lifetime, true
+		}
+		return 0, true
+	}
+
+	if heuristicFraction > 0 {
+		if lastModified, err := http.ParseTime(h.Get("Last-Modified")); err == nil {
+			if age := date.Sub(lastModified); age > 0 {
+				return time.Duration(float64(age) * heuristicFraction), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// responseDate returns the value of the Date header, or fallback if it's
+// missing or invalid.
+func responseDate(h http.Header, fallback time.Time) time.Time {
+	if date, err := http.ParseTime(h.Get("Date")); err == nil {
+		return date
+	}
+	return fallback
+}
+
+// initialAge returns the age of a response at the moment it was received
+// (RFC 9111, section 4.2.3).
+func initialAge(h http.Header, date, received time.Time) time.Duration {
+	age := received.Sub(date)
+	if age < 0 {
+		age = 0
+	}
+	if seconds, err := strconv.ParseUint(h.Get("Age"), 10, 32); err == nil {
+		if ageValue := time.Duration(seconds) * time.Second; ageValue > age {
+			age = ageValue
+		}
+	}
+	return age
+}
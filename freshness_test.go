@@ -0,0 +1,117 @@
+package httpcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_freshnessLifetime(t *testing.T) {
+	date := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		header     http.Header
+		expected   time.Duration
+		expectedOk bool
+	}{
+		{
+			name:       "no expiration",
+			header:     http.Header{},
+			expected:   0,
+			expectedOk: false,
+		},
+		{
+			name: "max-age wins over expires",
+			header: http.Header{
+				"Cache-Control": {"max-age=30"},
+				"Expires":       {date.Add(time.Hour).Format(http.TimeFormat)},
+			},
+			expected:   30 * time.Second,
+			expectedOk: true,
+		},
+		{
+			name: "s-maxage wins over max-age",
+			header: http.Header{
+				"Cache-Control": {"max-age=30, s-maxage=10"},
+			},
+			expected:   10 * time.Second,
+			expectedOk: true,
+		},
+		{
+			name: "expires",
+			header: http.Header{
+				"Expires": {date.Add(time.Hour).Format(http.TimeFormat)},
+			},
+			expected:   time.Hour,
+			expectedOk: true,
+		},
+		{
+			name: "expires in the past",
+			header: http.Header{
+				"Expires": {date.Add(-time.Hour).Format(http.TimeFormat)},
+			},
+			expected:   0,
+			expectedOk: true,
+		},
+		{
+			name: "invalid expires",
+			header: http.Header{
+				"Expires": {"0"},
+			},
+			expected:   0,
+			expectedOk: true,
+		},
+		{
+			name: "heuristic",
+			header: http.Header{
+				"Last-Modified": {date.Add(-10 * time.Hour).Format(http.TimeFormat)},
+			},
+			expected:   time.Hour,
+			expectedOk: true,
+		},
+		{
+			name: "last-modified in the future",
+			header: http.Header{
+				"Last-Modified": {date.Add(time.Hour).Format(http.TimeFormat)},
+			},
+			expected:   0,
+			expectedOk: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cc := parseCacheControl(testCase.header.Values("Cache-Control"))
+			lifetime, ok := freshnessLifetime(testCase.header, cc, date, 0.1)
+			if lifetime != testCase.expected || ok != testCase.expectedOk {
+				t.Errorf("expected (%s, %v), got (%s, %v)",
+					testCase.expected, testCase.expectedOk, lifetime, ok)
+			}
+		})
+	}
+}
+
+func Test_initialAge(t *testing.T) {
+	date := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		header   http.Header
+		received time.Time
+		expected time.Duration
+	}{
+		{name: "fresh", header: http.Header{}, received: date, expected: 0},
+		{name: "apparent age", header: http.Header{}, received: date.Add(5 * time.Second), expected: 5 * time.Second},
+		{name: "clock skew", header: http.Header{}, received: date.Add(-5 * time.Second), expected: 0},
+		{name: "age header", header: http.Header{"Age": {"30"}}, received: date.Add(5 * time.Second), expected: 30 * time.Second},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if age := initialAge(testCase.header, date, testCase.received); age != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, age)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package httpcache
+
+import (
+	"net/http"
+	"time"
+)
+
+// fillHead fills the cache on a HEAD request miss by running the handler
+// with a GET request, and answers with the header of the response. If the
+// body exceeds the maximum size, the header is sent as soon as it does.
+func (m middleware) fillHead(w http.ResponseWriter, r *http.Request, key uint64, reqCC cacheControl, cs cacheStatus) {
+	req := unconditionalRequest(r)
+	rec := m.newRecorder(headResponseWriter{w}, r, cs)
+	rec.hold = holdAlways
+	m.serve(rec, req)
+	if !rec.held() {
+		return
+	}
+
+	m.saveRecorded(req, key, reqCC, rec)
+	cs.fwdStatus = rec.statusCode
+	m.serveCachedResponse(w, r, newCachedResponse(rec, time.Now()), cs)
+}
+
+// bodyAllowed reports whether a response with the status may have a body.
+func bodyAllowed(status int) bool {
+	return (status < 100 || status > 199) && status != http.StatusNoContent && status != http.StatusNotModified
+}
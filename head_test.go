@@ -0,0 +1,137 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMiddlewareHead(t *testing.T) {
+	testCases := []struct {
+		name            string
+		options         []Option
+		requests        []string
+		expectedMethods []string
+		expectedSet     int
+	}{
+		{
+			name:            "head served from get",
+			requests:        []string{http.MethodGet, http.MethodHead},
+			expectedMethods: []string{http.MethodGet},
+			expectedSet:     1,
+		},
+		{
+			name:            "head miss",
+			requests:        []string{http.MethodHead, http.MethodGet},
+			expectedMethods: []string{http.MethodHead, http.MethodGet},
+			expectedSet:     1,
+		},
+		{
+			name:            "head miss with fill",
+			options:         []Option{WithHeadFill(true)},
+			requests:        []string{http.MethodHead, http.MethodGet, http.MethodHead},
+			expectedMethods: []string{http.MethodGet},
+			expectedSet:     1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var methods []string
+			store := &testStore{}
+			mw, err := NewMiddleware(store, testCase.options...)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				methods = append(methods, r.Method)
+				w.Header().Set("Content-Type", "text/plain")
+				if r.Method == http.MethodHead {
+					return
+				}
+				_, _ = w.Write([]byte("hello"))
+			}))
+
+			for _, method := range testCase.requests {
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest(method, "/", nil))
+
+				if rr.Code != http.StatusOK {
+					t.Errorf("%s: expected %d status code, got %d", method, http.StatusOK, rr.Code)
+				}
+				if ct := rr.Header().Get("Content-Type"); ct != "text/plain" {
+					t.Errorf("%s: expected Content-Type to be 'text/plain', got '%s'", method, ct)
+				}
+				expectedBody := "hello"
+				if method == http.MethodHead {
+					expectedBody = ""
+				}
+				if body := rr.Body.String(); body != expectedBody {
+					t.Errorf("%s: expected body to be '%s', got '%s'", method, expectedBody, body)
+				}
+			}
+
+			if len(methods) != len(testCase.expectedMethods) {
+				t.Fatalf("expected handler to be called with %v, got %v", testCase.expectedMethods, methods)
+			}
+			for i := range methods {
+				if methods[i] != testCase.expectedMethods[i] {
+					t.Errorf("expected handler to be called with %v, got %v", testCase.expectedMethods, methods)
+				}
+			}
+			if store.setCalled != testCase.expectedSet {
+				t.Errorf("expected store.Set to be called %d times, got %d", testCase.expectedSet, store.setCalled)
+			}
+		})
+	}
+}
+
+func TestMiddlewareHeadContentLength(t *testing.T) {
+	mw, err := NewMiddleware(&testStore{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodHead, "/", nil))
+
+	if cl := rr.Header().Get("Content-Length"); cl != "5" {
+		t.Errorf("expected Content-Length to be '5', got '%s'", cl)
+	}
+}
+
+func TestMiddlewareHeadMaxBodySize(t *testing.T) {
+	var methods []string
+	store := &testStore{}
+	mw, err := NewMiddleware(store, WithHeadFill(true), WithMaxBodySize(5))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.Header().Set("Content-Length", "11")
+		_, _ = w.Write([]byte("hello world"))
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodHead, "/", nil))
+
+	if cl := rr.Header().Get("Content-Length"); cl != "11" {
+		t.Errorf("expected Content-Length '11', got '%s'", cl)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected no body, got '%s'", rr.Body.String())
+	}
+	if !reflect.DeepEqual(methods, []string{http.MethodGet}) {
+		t.Errorf("expected handler to be called once with GET, got %q", methods)
+	}
+	if store.setCalled != 0 {
+		t.Errorf("expected store.Set not to be called, got %d", store.setCalled)
+	}
+}
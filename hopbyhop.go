@@ -0,0 +1,47 @@
+package httpcache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders are the header fields meaningful only for a single
+// connection (RFC 9110, section 7.6.1), which are neither stored nor served
+// from the cache. Proxy-* fields are treated as such too.
+var hopByHopHeaders = map[string]bool{
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Te":                true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// hopByHopFields returns the hop-by-hop fields of the header, including the
+// fields named in its Connection header.
+func hopByHopFields(h http.Header) map[string]bool {
+	fields := make(map[string]bool)
+	for k := range h {
+		if hopByHopHeaders[k] || strings.HasPrefix(k, "Proxy-") {
+			fields[k] = true
+		}
+	}
+	for _, v := range h.Values("Connection") {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				fields[http.CanonicalHeaderKey(k)] = true
+			}
+		}
+	}
+	return fields
+}
+
+// copyEndToEndHeader copies the header fields of src to dst, except the
+// hop-by-hop ones.
+func copyEndToEndHeader(dst http.Header, src http.Header) {
+	skip := hopByHopFields(src)
+	for k, v := range src {
+		if !skip[k] {
+			dst[k] = v
+		}
+	}
+}
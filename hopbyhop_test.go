@@ -0,0 +1,96 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func Test_hopByHopFields(t *testing.T) {
+	h := http.Header{
+		"Connection":          {"close, x-foo", "X-Bar"},
+		"Keep-Alive":          {"timeout=5"},
+		"Proxy-Authenticate":  {"Basic"},
+		"Transfer-Encoding":   {"chunked"},
+		"X-Foo":               {"1"},
+		"X-Bar":               {"2"},
+		"X-Baz":               {"3"},
+		"Content-Type":        {"text/plain"},
+		"Proxy-Authorization": {"Basic Zm9v"},
+	}
+
+	expected := map[string]bool{
+		"Connection":          true,
+		"Close":               true,
+		"Keep-Alive":          true,
+		"Proxy-Authenticate":  true,
+		"Proxy-Authorization": true,
+		"Transfer-Encoding":   true,
+		"X-Foo":               true,
+		"X-Bar":               true,
+	}
+	if fields := hopByHopFields(h); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %v, got %v", expected, fields)
+	}
+}
+
+func TestMiddlewareHopByHopHeaders(t *testing.T) {
+	store := &testStore{}
+	mw, err := NewMiddleware(store)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "X-Foo")
+		w.Header().Set("X-Foo", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("Proxy-Authenticate", "Basic")
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	for key := range store.data {
+		cr, err := (middleware{store: store}).getCachedResponse(context.Background(), key)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		for _, k := range []string{"Connection", "X-Foo", "Keep-Alive", "Proxy-Authenticate"} {
+			if v := cr.Header.Get(k); v != "" {
+				t.Errorf("expected %s not to be stored, got '%s'", k, v)
+			}
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/plain" {
+		t.Errorf("expected Content-Type to be 'text/plain', got '%s'", ct)
+	}
+	if cl := rr.Header().Get("Content-Length"); cl != "5" {
+		t.Errorf("expected Content-Length to be '5', got '%s'", cl)
+	}
+}
+
+func TestMiddlewareHopByHopHeadersOnReplay(t *testing.T) {
+	// entries stored before hop-by-hop fields were removed
+	rr := httptest.NewRecorder()
+	middleware{}.serveCachedResponse(rr, httptest.NewRequest(http.MethodGet, "/", nil), cachedResponse{
+		StatusCode: http.StatusOK,
+		Body:       []byte("hello"),
+		Header:     http.Header{"Connection": {"X-Foo"}, "X-Foo": {"1"}, "Upgrade": {"h2c"}, "X-Bar": {"2"}},
+	}, cacheStatus{})
+
+	for _, k := range []string{"Connection", "X-Foo", "Upgrade"} {
+		if v := rr.Header().Get(k); v != "" {
+			t.Errorf("expected %s not to be served, got '%s'", k, v)
+		}
+	}
+	if v := rr.Header().Get("X-Bar"); v != "2" {
+		t.Errorf("expected X-Bar to be '2', got '%s'", v)
+	}
+}
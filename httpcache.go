@@ -2,6 +2,7 @@ package httpcache
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/gob"
 	"errors"
@@ -10,17 +11,34 @@ import (
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"time"
 )
 
 var (
 	ErrNoEntry       = errors.New("not found")
 	ErrEntryIsTooBig = errors.New("entry exceeds capacity")
+
+	// errNoVariant is returned by lookup when a response is stored for the
+	// URL, but not for the variant selected by the request.
+	errNoVariant = errors.New("variant not found")
 )
 
 type Store interface {
 	Get(ctx context.Context, key uint64) ([]byte, error)
 	Set(ctx context.Context, key uint64, value []byte, ttl time.Duration) error
+	// Delete removes the value of key. Deleting a missing key is not an
+	// error.
+	Delete(ctx context.Context, key uint64) error
+}
+
+// Locker is implemented by stores able to coordinate cache fills across
+// instances sharing them. Lock tries to acquire the fill lock of key for ttl
+// without blocking and returns a token to release it with. Unlock releases
+// the lock only if it's still held with the token.
+type Locker interface {
+	Lock(ctx context.Context, key uint64, ttl time.Duration) (token string, acquired bool, err error)
+	Unlock(ctx context.Context, key uint64, token string) error
 }
 
 type keyGenerator interface {
@@ -43,6 +61,14 @@ func headerBypassCacheFunc(header string) BypassCacheFunc {
 	}
 }
 
+// TrustRequestFunc reports whether Cache-Control directives of the request
+// should be honored.
+type TrustRequestFunc func(r *http.Request) bool
+
+// CacheableRequestFunc reports whether a request with a method other than
+// GET and HEAD may be answered from the cache.
+type CacheableRequestFunc func(r *http.Request) bool
+
 // OnErrorFunc is a error handler callback.
 type OnErrorFunc func(err error)
 
@@ -52,24 +78,99 @@ func noopOnErrorFunc(_ error) {}
 type Option func(o *Options) error
 
 type Options struct {
-	ttl             time.Duration
-	bypassCacheFunc BypassCacheFunc
-	onError         OnErrorFunc
+	ttl                  time.Duration
+	heuristicFraction    float64
+	staleTTL             time.Duration
+	maxRefreshes         int
+	refreshTimeout       time.Duration
+	staleIfError         time.Duration
+	handlerTimeout       time.Duration
+	coalescing           bool
+	coalescingTimeout    time.Duration
+	fillLockTTL          time.Duration
+	fillLockWait         time.Duration
+	headFill             bool
+	cacheableRequestFunc CacheableRequestFunc
+	maxRequestBodySize   int64
+	canonicalizeBody     CanonicalizeBodyFunc
+	maxBodySize          int64
+	compressionLevel     int
+	statusTTLs           map[int]time.Duration
+	cacheName            string
+	cacheStatusFunc      CacheStatusFunc
+	setCookiePolicy      SetCookiePolicy
+	unstoredHeaders      []string
+	targetedFields       []string
+	bypassCacheFunc      BypassCacheFunc
+	trustRequestFunc     TrustRequestFunc
+	onError              OnErrorFunc
+}
+
+// defaultNegativeTTL is the TTL of the cacheable error responses, short so
+// that transient errors don't outlive their cause for long.
+const defaultNegativeTTL = 30 * time.Second
+
+// defaultStatusTTLs holds the heuristically cacheable status codes (RFC
+// 9110, section 15.1). Successful responses are stored for the default TTL,
+// errors for defaultNegativeTTL.
+var defaultStatusTTLs = map[int]time.Duration{
+	http.StatusOK:                   0,
+	http.StatusNonAuthoritativeInfo: 0,
+	http.StatusNoContent:            0,
+	http.StatusMultipleChoices:      0,
+	http.StatusMovedPermanently:     0,
+	http.StatusPermanentRedirect:    0,
+	http.StatusNotFound:             defaultNegativeTTL,
+	http.StatusMethodNotAllowed:     defaultNegativeTTL,
+	http.StatusGone:                 defaultNegativeTTL,
+	http.StatusRequestURITooLong:    defaultNegativeTTL,
+	http.StatusNotImplemented:       defaultNegativeTTL,
 }
 
 var defaultOptions = Options{
-	ttl:             24 * time.Hour,
-	bypassCacheFunc: headerBypassCacheFunc("X-Bypass-Cache"),
-	onError:         noopOnErrorFunc,
+	ttl:                24 * time.Hour,
+	heuristicFraction:  0.1,
+	staleTTL:           time.Hour,
+	maxRefreshes:       16,
+	refreshTimeout:     30 * time.Second,
+	maxRequestBodySize: 1 << 20,
+	statusTTLs:         defaultStatusTTLs,
+	cacheName:          "httpcache",
+	bypassCacheFunc:    headerBypassCacheFunc("X-Bypass-Cache"),
+	onError:            noopOnErrorFunc,
 }
 
 type middleware struct {
-	store       Store
-	next        http.Handler
-	keygen      keyGenerator
-	ttl         time.Duration
-	bypassCache BypassCacheFunc
-	onError     OnErrorFunc
+	store              Store
+	next               http.Handler
+	keygen             keyGenerator
+	ttl                time.Duration
+	heuristicFraction  float64
+	staleTTL           time.Duration
+	refreshes          *refreshGroup
+	refreshTimeout     time.Duration
+	staleIfError       time.Duration
+	handlerTimeout     time.Duration
+	fills              *fillGroup
+	coalescingTimeout  time.Duration
+	locker             Locker
+	fillLockTTL        time.Duration
+	fillLockWait       time.Duration
+	headFill           bool
+	cacheableRequest   CacheableRequestFunc
+	maxRequestBodySize int64
+	canonicalizeBody   CanonicalizeBodyFunc
+	maxBodySize        int64
+	compressionLevel   int
+	statusTTLs         map[int]time.Duration
+	cacheName          string
+	cacheStatus        CacheStatusFunc
+	setCookiePolicy    SetCookiePolicy
+	unstoredHeaders    []string
+	targetedFields     []string
+	bypassCache        BypassCacheFunc
+	trustRequest       TrustRequestFunc
+	onError            OnErrorFunc
 }
 
 func NewMiddleware(store Store, opts ...Option) (func(http.Handler) http.Handler, error) {
@@ -81,56 +182,320 @@ func NewMiddleware(store Store, opts ...Option) (func(http.Handler) http.Handler
 		}
 	}
 
+	var locker Locker
+	if options.fillLockTTL > 0 {
+		var ok bool
+		if locker, ok = store.(Locker); !ok {
+			return nil, errors.New("fill lock requires store to implement Locker")
+		}
+	}
+
 	return func(next http.Handler) http.Handler {
+		var fills *fillGroup
+		if options.coalescing {
+			fills = newFillGroup()
+		}
+
 		return &middleware{
-			store:       store,
-			next:        next,
-			keygen:      fnvHashKeyGenerator{},
-			ttl:         options.ttl,
-			bypassCache: options.bypassCacheFunc,
-			onError:     options.onError,
+			store:              store,
+			next:               next,
+			keygen:             fnvHashKeyGenerator{},
+			ttl:                options.ttl,
+			heuristicFraction:  options.heuristicFraction,
+			staleTTL:           options.staleTTL,
+			refreshes:          newRefreshGroup(options.maxRefreshes),
+			refreshTimeout:     options.refreshTimeout,
+			staleIfError:       options.staleIfError,
+			handlerTimeout:     options.handlerTimeout,
+			fills:              fills,
+			coalescingTimeout:  options.coalescingTimeout,
+			locker:             locker,
+			fillLockTTL:        options.fillLockTTL,
+			fillLockWait:       options.fillLockWait,
+			headFill:           options.headFill,
+			cacheableRequest:   options.cacheableRequestFunc,
+			maxRequestBodySize: options.maxRequestBodySize,
+			canonicalizeBody:   options.canonicalizeBody,
+			maxBodySize:        options.maxBodySize,
+			compressionLevel:   options.compressionLevel,
+			statusTTLs:         options.statusTTLs,
+			cacheName:          options.cacheName,
+			cacheStatus:        options.cacheStatusFunc,
+			setCookiePolicy:    options.setCookiePolicy,
+			unstoredHeaders:    options.unstoredHeaders,
+			targetedFields:     options.targetedFields,
+			bypassCache:        options.bypassCacheFunc,
+			trustRequest:       options.trustRequestFunc,
+			onError:            options.onError,
 		}
 	}, nil
 }
 
 func (m middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !m.isCacheable(r) || m.bypassCache(r) {
-		m.next.ServeHTTP(w, r)
+	if !m.isCacheable(r) {
+		rec := m.passThrough(w, r, cacheStatus{fwd: fwdMethod})
+		if !safeMethods[r.Method] {
+			m.invalidateAfter(r, rec)
+		}
+		return
+	}
+	if m.bypassCache(r) {
+		m.passThrough(w, r, cacheStatus{fwd: fwdBypass})
 		return
 	}
 
-	key := m.generateKey(r.URL)
-	cr, err := m.getCachedResponse(r.Context(), key)
-	if err == ErrNoEntry {
-		rec := newHttpResponseRecorder(w)
-		m.next.ServeHTTP(rec, r)
+	reqCC := m.requestCacheControl(r)
+	key, ok := m.requestKey(r)
+	if !ok {
+		m.passThrough(w, r, cacheStatus{fwd: fwdBypass, detail: "request-body"})
+		return
+	}
+	cs := cacheStatus{key: strconv.FormatUint(key, 10)}
+
+	cr, err := m.lookup(r.Context(), key, r)
+	if err != nil && err != ErrNoEntry && err != errNoVariant {
+		m.onError(err)
+		// Some error has occurred. Gracefully degrade - simply proceed
+		// with the normal flow
+		cs.fwd, cs.detail = fwdMiss, "store-error"
+		m.passThrough(w, r, cs)
+		return
+	}
+	found := err == nil
+	now := time.Now()
 
-		if rec.statusCode >= 400 { // do not cache errors
+	if found && !reqCC.has("no-cache") && cr.satisfies(reqCC, now) {
+		cs.hit = true
+		m.serveCachedResponse(w, r, cr, cs)
+		return
+	}
+
+	if found && cr.staleWhileRevalidate(reqCC, now) {
+		m.refreshInBackground(r, key, cr)
+		cs.hit = true
+		m.serveCachedResponse(w, r, cr, cs)
+		return
+	}
+
+	switch {
+	case err == ErrNoEntry:
+		cs.fwd = fwdURIMiss
+	case err == errNoVariant:
+		cs.fwd = fwdVaryMiss
+	case reqCC.has("no-cache") || cr.FreshUntil.After(now):
+		cs.fwd = fwdRequest
+	default:
+		cs.fwd = fwdStale
+	}
+
+	if reqCC.has("only-if-cached") {
+		m.setCacheStatus(w.Header(), r, cs)
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		if !m.headFill {
+			m.passThrough(w, r, cs)
 			return
 		}
+		m.fillHead(w, r, key, reqCC, cs)
+		return
+	}
 
-		if err := m.saveCachedResponse(r.Context(), key, newCachedResponse(rec)); err != nil {
-			m.onError(err)
+	var stale *cachedResponse
+	if found {
+		stale = &cr
+	}
+	m.fill(w, r, key, reqCC, stale, cs)
+}
+
+// serve runs the handler with the recorder. If the handler panics, the
+// response is marked as failed and the panic is propagated, for the server
+// to abort the response.
+func (m middleware) serve(rec *httpResponseRecorder, r *http.Request) {
+	defer func() {
+		if p := recover(); p != nil {
+			rec.failed = true
+			panic(p)
 		}
-		return
+	}()
+
+	m.next.ServeHTTP(rec.responseWriter(), r)
+	rec.finish()
+}
+
+// passThrough runs the handler without storing its response, which is
+// passed through like those of cache fills.
+func (m middleware) passThrough(w http.ResponseWriter, r *http.Request, cs cacheStatus) *httpResponseRecorder {
+	rec := m.newRecorder(w, r, cs)
+	rec.discardBody = true
+	m.serve(rec, r)
+	return rec
+}
+
+// bufferRecorder returns a recorder buffering the handler response without
+// passing it through, up to the maximum body size.
+func (m middleware) bufferRecorder() *httpResponseRecorder {
+	rec := newHttpResponseRecorder(&discardResponseWriter{})
+	rec.maxBodySize = m.maxBodySize
+	return rec
+}
+
+// saveRecorded stores the response captured by rec if it's cacheable. It
+// returns the response and whether it's cacheable.
+func (m middleware) saveRecorded(r *http.Request, key uint64, reqCC cacheControl, rec *httpResponseRecorder) (cachedResponse, bool) {
+	if reqCC.has("no-store") {
+		return cachedResponse{}, false
+	}
+	if !rec.storable() || m.maxBodySize > 0 && int64(rec.body.Len()) > m.maxBodySize {
+		return cachedResponse{}, false
+	}
+
+	res := newCachedResponse(rec, time.Now())
+	if !m.admit(r, &res) {
+		return cachedResponse{}, false
+	}
+
+	if err := m.storeResponse(r.Context(), key, r, res); err != nil {
+		m.onError(err)
+	}
+	return res, true
+}
+
+// lookup returns the response stored for the request, selecting the
+// variant matching request headers if the response has a Vary header.
+// It returns errNoVariant if no response is stored for the variant.
+func (m middleware) lookup(ctx context.Context, key uint64, r *http.Request) (cachedResponse, error) {
+	cr, err := m.getCachedResponse(ctx, key)
+	if err != nil || len(cr.Vary) == 0 {
+		return cr, err
+	}
+	cr, err = m.getCachedResponse(ctx, m.secondaryKey(key, cr.Vary, r.Header))
+	if err == ErrNoEntry {
+		return cr, errNoVariant
+	}
+	return cr, err
+}
+
+// storeResponse saves the response to the request until its KeepUntil time.
+// Responses with a Vary header are saved under the secondary key, and the
+// primary key holds the list of request fields used to build it. The primary
+// entry is kept as long as the longest-lived variant stored with the same
+// fields.
+func (m middleware) storeResponse(ctx context.Context, key uint64, r *http.Request, res cachedResponse) error {
+	res = m.compress(res)
+	ttl := res.KeepUntil.Sub(res.StoredAt)
+	fields := varyFields(res.Header)
+	if len(fields) == 0 {
+		return m.saveCachedResponse(ctx, key, res, ttl)
 	}
+
+	primary := cachedResponse{Vary: fields, StoredAt: res.StoredAt, KeepUntil: res.KeepUntil}
+	if cur, err := m.getCachedResponse(ctx, key); err == nil && equalFields(cur.Vary, fields) && cur.KeepUntil.After(primary.KeepUntil) {
+		primary.KeepUntil = cur.KeepUntil
+	}
+	if err := m.saveCachedResponse(ctx, key, primary, primary.KeepUntil.Sub(primary.StoredAt)); err != nil {
+		return err
+	}
+	return m.saveCachedResponse(ctx, m.secondaryKey(key, fields, r.Header), res, ttl)
+}
+
+// serveCachedResponse answers the request with the response, adding the
+// Cache-Status header described by cs. The Age header is set if the response
+// is served from the store, as opposed to just received from the handler.
+func (m middleware) serveCachedResponse(w http.ResponseWriter, r *http.Request, cr cachedResponse, cs cacheStatus) {
+	now := time.Now()
+	if !cr.FreshUntil.IsZero() {
+		cs.ttl, cs.hasTTL = cr.FreshUntil.Sub(now), true
+	}
+	age := cs.hit || cs.fromCache
+
+	// Preconditions are evaluated against the stored validators, the ETag
+	// of a compressed body is only weakened in the header sent.
+	stored := cr.Header
+	cr, err := m.selectEncoding(r, cr)
 	if err != nil {
 		m.onError(err)
-		// Some error has occurred. Gracefully degrade - simply proceed
-		// with the normal flow
-		m.next.ServeHTTP(w, r)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	copyHeader(w.Header(), cr.Header)
+	if cr.StatusCode >= 200 && cr.StatusCode < 300 {
+		switch status := evaluatePreconditions(r, stored); status {
+		case http.StatusNotModified:
+			for _, k := range notModifiedHeaders {
+				if v := cr.Header.Values(k); len(v) > 0 {
+					w.Header()[http.CanonicalHeaderKey(k)] = v
+				}
+			}
+			if age {
+				w.Header().Set("Age", formatAge(cr.age(now)))
+			}
+			m.setCacheStatus(w.Header(), r, cs)
+			w.WriteHeader(status)
+			return
+		case http.StatusPreconditionFailed:
+			m.setCacheStatus(w.Header(), r, cs)
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	copyEndToEndHeader(w.Header(), cr.Header)
+	m.removeTargetedFields(w.Header())
+	if age {
+		w.Header().Set("Age", formatAge(cr.age(now)))
+	}
+	m.setCacheStatus(w.Header(), r, cs)
+	if cr.StatusCode == http.StatusOK {
+		if w.Header().Get("Accept-Ranges") == "" {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+		if isRangeRequest(r) && m.serveRange(w, r, cr) {
+			return
+		}
+	}
+	if r.Method == http.MethodHead {
+		if bodyAllowed(cr.StatusCode) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(cr.Body)))
+		}
+		w.WriteHeader(cr.StatusCode)
+		return
+	}
+	// Trailers can only be sent with the chunked transfer coding.
+	if bodyAllowed(cr.StatusCode) && cr.Header.Get("Trailer") == "" && len(cr.Trailer) == 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(cr.Body)))
+	}
 	w.WriteHeader(cr.StatusCode)
 	if _, err := w.Write(cr.Body); err != nil {
 		m.onError(err)
 	}
+	copyHeader(w.Header(), cr.Trailer)
+}
+
+// requestCacheControl returns the request Cache-Control directives, or nil
+// if the request is not trusted to send them.
+func (m middleware) requestCacheControl(r *http.Request) cacheControl {
+	if m.trustRequest == nil || !m.trustRequest(r) {
+		return nil
+	}
+	return parseCacheControl(r.Header.Values("Cache-Control"))
 }
 
 func (m middleware) isCacheable(r *http.Request) bool {
-	return r.Method == http.MethodGet
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	return m.cacheableRequest != nil && m.cacheableRequest(r)
+}
+
+// formatAge formats the age as the Age header value, in whole seconds.
+func formatAge(age time.Duration) string {
+	if age < 0 {
+		age = 0
+	}
+	return strconv.FormatInt(int64(age/time.Second), 10)
 }
 
 func (m middleware) generateKey(u *url.URL) uint64 {
@@ -139,13 +504,84 @@ func (m middleware) generateKey(u *url.URL) uint64 {
 	return m.keygen.Generate(urlCopy.String())
 }
 
-func (m middleware) saveCachedResponse(ctx context.Context, key uint64, res cachedResponse) error {
+// setExpiration sets the times the response to r becomes stale and is
+// evicted, honoring its cache directives and expiration headers. The TTL
+// configured for the response status is used when the response doesn't
+// specify a lifetime, and caps it otherwise. Responses to methods other than
+// GET and HEAD must specify it explicitly (RFC 9111, section 3). Responses
+// having validators are kept stale for revalidation. It returns false if the
+// response must not be stored.
+func (m middleware) setExpiration(r *http.Request, res *cachedResponse) bool {
+	ttl, ok := m.statusTTLs[res.StatusCode]
+	if !ok {
+		return false
+	}
+	if ttl == 0 {
+		ttl = m.ttl
+	}
+
+	cc := res.cacheControl()
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	if varyAll(varyFields(res.Header)) {
+		return false
+	}
+
+	h := res.Header
+	if res.CacheControl != nil { // Expires is ignored along with Cache-Control
+		h = h.Clone()
+		h.Del("Expires")
+	}
+	heuristicFraction := m.heuristicFraction
+	explicitOnly := r.Method != http.MethodGet && r.Method != http.MethodHead
+	if explicitOnly {
+		heuristicFraction = 0
+	}
+	lifetime, ok := freshnessLifetime(h, cc, res.Date, heuristicFraction)
+	if !ok && explicitOnly {
+		return false
+	}
+	if !ok || lifetime > ttl {
+		lifetime = ttl
+	}
+	if cc.has("no-cache") {
+		lifetime = 0
+	}
+
+	res.FreshUntil = res.StoredAt.Add(lifetime - initialAge(res.Header, res.Date, res.StoredAt))
+	res.KeepUntil = res.FreshUntil
+	if res.hasValidators() {
+		res.KeepUntil = res.KeepUntil.Add(m.staleTTL)
+	}
+	if swr, ok := cc.seconds("stale-while-revalidate"); ok && res.FreshUntil.Add(swr).After(res.KeepUntil) {
+		res.KeepUntil = res.FreshUntil.Add(swr)
+	}
+	if sie := staleIfErrorWindow(cc, m.staleIfError); res.FreshUntil.Add(sie).After(res.KeepUntil) {
+		res.KeepUntil = res.FreshUntil.Add(sie)
+	}
+	return res.KeepUntil.After(res.StoredAt)
+}
+
+// mayStore reports whether a response with the status and header may be
+// stored, as far as can be told before its body is received.
+func (m middleware) mayStore(statusCode int, h http.Header) bool {
+	if _, ok := m.statusTTLs[statusCode]; !ok {
+		return false
+	}
+	res := cachedResponse{Header: h}
+	m.selectCacheControl(&res)
+	cc := res.cacheControl()
+	return !cc.has("no-store") && !cc.has("private")
+}
+
+func (m middleware) saveCachedResponse(ctx context.Context, key uint64, res cachedResponse, ttl time.Duration) error {
 	var buf bytes.Buffer
 	if err := gob.NewEncoder(&buf).Encode(res); err != nil {
 		return fmt.Errorf("failed to encode object: %v", err)
 	}
 
-	if err := m.store.Set(ctx, key, buf.Bytes(), m.ttl); err != nil {
+	if err := m.store.Set(ctx, key, buf.Bytes(), ttl); err != nil {
 		return fmt.Errorf("failed to save response to store: %v", err)
 	}
 	return nil
@@ -183,17 +619,100 @@ type cachedResponse struct {
 	StatusCode int
 	Body       []byte
 	Header     http.Header
+
+	// StoredAt is the time the response was received from the handler.
+	StoredAt time.Time
+	// Date is the time the response was generated, taken from the Date
+	// header if present.
+	Date time.Time
+	// FreshUntil is the time the response becomes stale.
+	FreshUntil time.Time
+	// KeepUntil is the time the response is evicted from the store. Stale
+	// responses are kept to be revalidated or served when allowed.
+	KeepUntil time.Time
+
+	// Trailer holds the trailer fields sent after the body, keyed either by
+	// names declared in the Trailer header or with the http.TrailerPrefix
+	// prefix.
+	Trailer http.Header
+
+	// CacheControl holds the value of the targeted cache control field
+	// selected for the response, whose directives are used instead of
+	// those of the Cache-Control header.
+	CacheControl []string
+
+	// Vary is set on the primary entry of a response with a Vary header.
+	// Such an entry holds no response, variants are stored under secondary
+	// keys built from the values of these request header fields.
+	Vary []string
+
+	// Encoding is the content coding the body is stored with, when it's
+	// compressed by the cache rather than by the handler.
+	Encoding string
 }
 
-func newCachedResponse(rec *httpResponseRecorder) cachedResponse {
+func newCachedResponse(rec *httpResponseRecorder, now time.Time) cachedResponse {
+	header := rec.Header().Clone()
+	trailer := rec.trailer()
+	for k := range trailer {
+		delete(header, k)
+	}
+	for k := range hopByHopFields(header) {
+		delete(header, k)
+	}
+	date := responseDate(header, now)
+	if header.Get("Date") == "" {
+		header.Set("Date", date.UTC().Format(http.TimeFormat))
+	}
+
 	return cachedResponse{
 		StatusCode: rec.statusCode,
 		Body:       rec.body.Bytes(),
-		Header:     rec.Header(),
+		Header:     header,
+		Trailer:    trailer,
+		StoredAt:   now,
+		Date:       date,
 	}
 }
 
-// WithTTL sets the TTL for cache items
+// age returns the current age of the response (RFC 9111, section 4.2.3).
+func (cr cachedResponse) age(now time.Time) time.Duration {
+	return initialAge(cr.Header, cr.Date, cr.StoredAt) + now.Sub(cr.StoredAt)
+}
+
+// satisfies reports whether the response may be used to satisfy a request
+// with the given Cache-Control directives (RFC 9111, section 5.2.1).
+func (cr cachedResponse) satisfies(reqCC cacheControl, now time.Time) bool {
+	freshness := cr.FreshUntil.Sub(now)
+
+	if maxAge, ok := reqCC.seconds("max-age"); ok && cr.age(now) > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok && freshness < minFresh {
+		return false
+	}
+	if freshness > 0 {
+		return true
+	}
+
+	if !reqCC.has("max-stale") || cr.mustRevalidate() {
+		return false
+	}
+	if reqCC["max-stale"] == "" { // any staleness is acceptable
+		return true
+	}
+	maxStale, _ := reqCC.seconds("max-stale")
+	return -freshness <= maxStale
+}
+
+// mustRevalidate reports whether the response must not be served stale.
+func (cr cachedResponse) mustRevalidate() bool {
+	cc := cr.cacheControl()
+	return cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("s-maxage")
+}
+
+// WithTTL sets the TTL for cache items. It's used for responses which don't
+// specify their lifetime and caps the lifetime of those which do.
 func WithTTL(ttl time.Duration) Option {
 	return func(o *Options) error {
 		if ttl == 0 {
@@ -206,6 +725,373 @@ func WithTTL(ttl time.Duration) Option {
 	}
 }
 
+// WithHeuristicFraction sets the fraction of the time since Last-Modified
+// used as the freshness lifetime of responses without explicit expiration.
+// Zero disables heuristic freshness. Default: 0.1
+func WithHeuristicFraction(fraction float64) Option {
+	return func(o *Options) error {
+		if fraction < 0 || fraction > 1 {
+			return errors.New("fraction must be within [0, 1]")
+		}
+
+		o.heuristicFraction = fraction
+
+		return nil
+	}
+}
+
+// WithStaleTTL sets how long responses having validators (ETag or
+// Last-Modified) are kept after they become stale, so that they can be
+// revalidated with a conditional request instead of being fetched again.
+// Default: 1h
+func WithStaleTTL(ttl time.Duration) Option {
+	return func(o *Options) error {
+		if ttl < 0 {
+			return errors.New("ttl must be >= 0")
+		}
+
+		o.staleTTL = ttl
+
+		return nil
+	}
+}
+
+// WithMaxBackgroundRefreshes sets the maximum number of concurrent
+// background refreshes of responses served stale-while-revalidate. Stale
+// responses are served without a refresh when the limit is reached.
+// Default: 16
+func WithMaxBackgroundRefreshes(n int) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return errors.New("n must be > 0")
+		}
+
+		o.maxRefreshes = n
+
+		return nil
+	}
+}
+
+// WithBackgroundRefreshTimeout sets the timeout of background refreshes.
+// Default: 30s
+func WithBackgroundRefreshTimeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		if timeout <= 0 {
+			return errors.New("timeout must be > 0")
+		}
+
+		o.refreshTimeout = timeout
+
+		return nil
+	}
+}
+
+// WithStaleIfError sets how long after becoming stale a response may be
+// served when the handler fails to refresh it, with a 5xx status, a panic or
+// a timeout. The stale-if-error response directive takes precedence.
+// Default: 0
+func WithStaleIfError(window time.Duration) Option {
+	return func(o *Options) error {
+		if window < 0 {
+			return errors.New("window must be >= 0")
+		}
+
+		o.staleIfError = window
+
+		return nil
+	}
+}
+
+// WithHandlerTimeout sets how long the handler may take to refresh a
+// response which can be served stale-if-error. When the timeout is exceeded
+// the stale response is served. Default: no timeout
+func WithHandlerTimeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		if timeout <= 0 {
+			return errors.New("timeout must be > 0")
+		}
+
+		o.handlerTimeout = timeout
+
+		return nil
+	}
+}
+
+// WithRequestCoalescing enables collapsing of concurrent requests missing
+// the same key, or refreshing the same stale response: one request runs the
+// handler and fills the cache while others wait up to timeout to be served
+// its response.
+func WithRequestCoalescing(timeout time.Duration) Option {
+	return func(o *Options) error {
+		if timeout <= 0 {
+			return errors.New("timeout must be > 0")
+		}
+
+		o.coalescing = true
+		o.coalescingTimeout = timeout
+
+		return nil
+	}
+}
+
+// WithFillLock enables coordination of cache fills across instances sharing
+// the store, which must implement Locker. Only the instance holding the fill
+// lock of a key, acquired for ttl, runs the handler to fill the cache or
+// revalidate the stale response. Others serve the stale response if allowed,
+// or poll the store for up to wait before running the handler themselves.
+func WithFillLock(ttl, wait time.Duration) Option {
+	return func(o *Options) error {
+		if ttl <= 0 {
+			return errors.New("ttl must be > 0")
+		}
+		if wait <= 0 {
+			return errors.New("wait must be > 0")
+		}
+
+		o.fillLockTTL = ttl
+		o.fillLockWait = wait
+
+		return nil
+	}
+}
+
+// WithHeadFill sets whether HEAD requests missing the cache fill it, by
+// running the handler with a GET request. Otherwise they are passed to the
+// handler as is. HEAD requests are answered from stored GET responses
+// either way. Default: false
+func WithHeadFill(enabled bool) Option {
+	return func(o *Options) error {
+		o.headFill = enabled
+
+		return nil
+	}
+}
+
+// WithCacheableRequests makes requests with methods other than GET and HEAD
+// cacheable when accepted by f, e.g. POST requests to the endpoints used for
+// idempotent reads. Keys of such requests include the method and the request
+// body, and their responses are stored only with an explicit lifetime. They
+// don't invalidate stored responses, as other requests with unsafe methods
+// do. Default: none
+func WithCacheableRequests(f CacheableRequestFunc) Option {
+	return func(o *Options) error {
+		if f == nil {
+			return errors.New("function must not be nil")
+		}
+
+		o.cacheableRequestFunc = f
+
+		return nil
+	}
+}
+
+// WithMaxRequestBodySize sets the maximum size of request bodies read to
+// generate cache keys. Requests with larger bodies are not cached.
+// Default: 1MB
+func WithMaxRequestBodySize(bytes int64) Option {
+	return func(o *Options) error {
+		if bytes <= 0 {
+			return errors.New("bytes must be > 0")
+		}
+
+		o.maxRequestBodySize = bytes
+
+		return nil
+	}
+}
+
+// WithBodyCanonicalizer sets the function returning the canonical form of
+// request bodies used in cache keys. Requests which bodies fail to be
+// canonicalized are not cached. Default: bodies are used as is
+func WithBodyCanonicalizer(f CanonicalizeBodyFunc) Option {
+	return func(o *Options) error {
+		if f == nil {
+			return errors.New("function must not be nil")
+		}
+
+		o.canonicalizeBody = f
+
+		return nil
+	}
+}
+
+// WithMaxBodySize sets the maximum size of response bodies stored. Larger
+// responses are streamed to the client without being buffered beyond the
+// limit, and not stored. Up to that size, bodies of responses which may be
+// stored are buffered in memory while the handler runs, for each concurrent
+// cache miss. This includes range requests for which the full response is
+// fetched, only the requested range being sent to the client. Default: no
+// limit
+func WithMaxBodySize(bytes int64) Option {
+	return func(o *Options) error {
+		if bytes <= 0 {
+			return errors.New("bytes must be > 0")
+		}
+
+		o.maxBodySize = bytes
+
+		return nil
+	}
+}
+
+// WithCompression makes bodies stored compressed with gzip at the level,
+// unless the handler encoded them already. Compressed bodies are served as
+// is to clients accepting gzip and decompressed for others. Default: not
+// compressed
+func WithCompression(level int) Option {
+	return func(o *Options) error {
+		if level != gzip.DefaultCompression && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+			return fmt.Errorf("invalid compression level %d", level)
+		}
+
+		o.compressionLevel = level
+
+		return nil
+	}
+}
+
+// WithCacheableStatuses replaces the set of status codes of responses which
+// may be stored, for the default TTL, or 30 seconds for the default error
+// statuses. Default: 200, 203, 204, 300, 301, 308, and 404, 405, 410, 414,
+// 501 for 30 seconds
+func WithCacheableStatuses(statuses ...int) Option {
+	return func(o *Options) error {
+		if len(statuses) == 0 {
+			return errors.New("statuses must not be empty")
+		}
+
+		o.statusTTLs = make(map[int]time.Duration, len(statuses))
+		for _, status := range statuses {
+			if err := validateCacheableStatus(status); err != nil {
+				return err
+			}
+			o.statusTTLs[status] = defaultStatusTTLs[status]
+		}
+
+		return nil
+	}
+}
+
+// WithStatusTTL makes responses with the status code cacheable and sets
+// their TTL, used in place of the one set by WithTTL.
+func WithStatusTTL(status int, ttl time.Duration) Option {
+	return func(o *Options) error {
+		if err := validateCacheableStatus(status); err != nil {
+			return err
+		}
+		if ttl <= 0 {
+			return errors.New("ttl must be > 0")
+		}
+
+		statusTTLs := make(map[int]time.Duration, len(o.statusTTLs)+1)
+		for k, v := range o.statusTTLs {
+			statusTTLs[k] = v
+		}
+		statusTTLs[status] = ttl
+		o.statusTTLs = statusTTLs
+
+		return nil
+	}
+}
+
+func validateCacheableStatus(status int) error {
+	if status < 200 || status > 599 {
+		return fmt.Errorf("invalid status %d", status)
+	}
+	if status == http.StatusPartialContent || status == http.StatusNotModified {
+		return fmt.Errorf("status %d can't be stored as a full response", status)
+	}
+	return nil
+}
+
+// WithCacheName sets the name identifying the cache in the Cache-Status
+// header. It must be a structured field token. Default: httpcache
+func WithCacheName(name string) Option {
+	return func(o *Options) error {
+		if !validCacheName(name) {
+			return fmt.Errorf("invalid cache name %q", name)
+		}
+
+		o.cacheName = name
+
+		return nil
+	}
+}
+
+// WithCacheStatus sends the Cache-Status header only in responses to
+// requests accepted by f, e.g. to hide it from public traffic. The header is
+// sent to all clients by default. The Age header, required by RFC 9111 on
+// responses served from the cache, is always sent.
+func WithCacheStatus(f CacheStatusFunc) Option {
+	return func(o *Options) error {
+		if f == nil {
+			return errors.New("function must not be nil")
+		}
+
+		o.cacheStatusFunc = f
+
+		return nil
+	}
+}
+
+// WithSetCookiePolicy sets how responses with a Set-Cookie header are
+// stored. Default: SetCookieSkip
+func WithSetCookiePolicy(policy SetCookiePolicy) Option {
+	return func(o *Options) error {
+		if policy != SetCookieSkip && policy != SetCookieStrip {
+			return fmt.Errorf("invalid Set-Cookie policy %d", policy)
+		}
+
+		o.setCookiePolicy = policy
+
+		return nil
+	}
+}
+
+// WithUnstoredHeaders sets response header fields which are never stored.
+// They are removed from responses before storing them, and only sent in the
+// response passed through from the handler.
+func WithUnstoredHeaders(headers ...string) Option {
+	return func(o *Options) error {
+		if len(headers) == 0 {
+			return errors.New("headers must not be empty")
+		}
+
+		o.unstoredHeaders = make([]string, 0, len(headers))
+		for _, header := range headers {
+			if header == "" {
+				return errors.New("header must not be empty")
+			}
+			o.unstoredHeaders = append(o.unstoredHeaders, http.CanonicalHeaderKey(header))
+		}
+
+		return nil
+	}
+}
+
+// WithTargetedCacheControl sets the targeted cache control fields of the
+// cache, e.g. CDN-Cache-Control (RFC 9213) or Surrogate-Control, in order of
+// precedence. The directives of the first field present in a response are
+// used instead of its Cache-Control and Expires headers. The fields are
+// removed from responses sent to clients.
+func WithTargetedCacheControl(fields ...string) Option {
+	return func(o *Options) error {
+		if len(fields) == 0 {
+			return errors.New("fields must not be empty")
+		}
+
+		o.targetedFields = make([]string, 0, len(fields))
+		for _, field := range fields {
+			if field == "" {
+				return errors.New("field must not be empty")
+			}
+			o.targetedFields = append(o.targetedFields, http.CanonicalHeaderKey(field))
+		}
+
+		return nil
+	}
+}
+
 // WithBypassCacheHeader sets cache bypass header. Default: X-Bypass-Cache
 func WithBypassCacheHeader(header string) Option {
 	return func(o *Options) error {
@@ -219,6 +1105,21 @@ func WithBypassCacheHeader(header string) Option {
 	}
 }
 
+// WithRequestCacheControl enables request Cache-Control directives
+// (no-cache, no-store, max-age, max-stale, min-fresh and only-if-cached)
+// for requests accepted by f. Directives are ignored by default.
+func WithRequestCacheControl(f TrustRequestFunc) Option {
+	return func(o *Options) error {
+		if f == nil {
+			return errors.New("function must not be nil")
+		}
+
+		o.trustRequestFunc = f
+
+		return nil
+	}
+}
+
 // WithOnErrorFunc sets cache error callback handler
 func WithOnErrorFunc(f OnErrorFunc) Option {
 	return func(o *Options) error {
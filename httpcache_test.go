@@ -2,20 +2,30 @@ package httpcache
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
 
 type testStore struct {
-	data map[uint64][]byte
+	mutex sync.Mutex
+	data  map[uint64][]byte
 
-	getCalled int
-	setCalled int
+	getCalled    int
+	setCalled    int
+	deleteCalled int
+	lastTTL      time.Duration
 }
 
 func (s *testStore) Get(_ context.Context, key uint64) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	s.getCalled++
 	if s.data == nil {
 		s.data = make(map[uint64][]byte)
@@ -27,8 +37,12 @@ func (s *testStore) Get(_ context.Context, key uint64) ([]byte, error) {
 	return val, nil
 }
 
-func (s *testStore) Set(_ context.Context, key uint64, value []byte, _ time.Duration) error {
+func (s *testStore) Set(_ context.Context, key uint64, value []byte, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	s.setCalled++
+	s.lastTTL = ttl
 	if s.data == nil {
 		s.data = make(map[uint64][]byte)
 	}
@@ -36,6 +50,15 @@ func (s *testStore) Set(_ context.Context, key uint64, value []byte, _ time.Dura
 	return nil
 }
 
+func (s *testStore) Delete(_ context.Context, key uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.deleteCalled++
+	delete(s.data, key)
+	return nil
+}
+
 func TestMiddleware(t *testing.T) {
 	var handler http.Handler
 
@@ -115,7 +138,7 @@ func TestMiddleware(t *testing.T) {
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
 			store := &testStore{}
-			mw, err := NewMiddleware(store)
+			mw, err := NewMiddleware(store, WithCacheableStatuses(http.StatusCreated))
 			if err != nil {
 				t.Fatal("unexpected error", err)
 			}
@@ -142,6 +165,112 @@ func TestMiddleware(t *testing.T) {
 	}
 }
 
+func TestMiddlewareResponseCacheControl(t *testing.T) {
+	testCases := []struct {
+		name        string
+		header      string
+		expectedSet int
+		expectedTTL time.Duration
+	}{
+		{name: "no directives", header: "", expectedSet: 1, expectedTTL: time.Hour},
+		{name: "no-store", header: "no-store", expectedSet: 0},
+		{name: "private", header: "private, max-age=60", expectedSet: 0},
+		{name: "max-age", header: "max-age=60", expectedSet: 1, expectedTTL: time.Minute},
+		{name: "s-maxage wins", header: "max-age=60, s-maxage=5", expectedSet: 1, expectedTTL: 5 * time.Second},
+		{name: "capped by ttl", header: "max-age=86400", expectedSet: 1, expectedTTL: time.Hour},
+		{name: "zero max-age", header: "max-age=0", expectedSet: 0},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			store := &testStore{}
+			mw, err := NewMiddleware(store, WithTTL(time.Hour))
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if testCase.header != "" {
+					w.Header().Set("Cache-Control", testCase.header)
+				}
+				_, _ = w.Write([]byte("hello"))
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if store.setCalled != testCase.expectedSet {
+				t.Errorf("expected store.Set to be called %d times, got %d",
+					testCase.expectedSet, store.setCalled)
+			}
+			if store.lastTTL != testCase.expectedTTL {
+				t.Errorf("expected ttl to be %s, got %s", testCase.expectedTTL, store.lastTTL)
+			}
+		})
+	}
+}
+
+func TestMiddlewareExpiration(t *testing.T) {
+	date := time.Now().UTC().Truncate(time.Second)
+
+	testCases := []struct {
+		name        string
+		header      http.Header
+		expectedSet int
+		expectedTTL time.Duration
+	}{
+		{
+			name: "expires",
+			header: http.Header{
+				"Date":    {date.Format(http.TimeFormat)},
+				"Expires": {date.Add(10 * time.Minute).Format(http.TimeFormat)},
+			},
+			expectedSet: 1,
+			expectedTTL: 10 * time.Minute,
+		},
+		{
+			name: "expired",
+			header: http.Header{
+				"Date":    {date.Format(http.TimeFormat)},
+				"Expires": {date.Add(-time.Minute).Format(http.TimeFormat)},
+			},
+			expectedSet: 0,
+		},
+		{
+			name: "last-modified",
+			header: http.Header{
+				"Date":          {date.Format(http.TimeFormat)},
+				"Last-Modified": {date.Add(-100 * time.Minute).Format(http.TimeFormat)},
+			},
+			expectedSet: 1,
+			expectedTTL: 10 * time.Minute,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			store := &testStore{}
+			mw, err := NewMiddleware(store, WithTTL(time.Hour), WithStaleTTL(0))
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				copyHeader(w.Header(), testCase.header)
+				_, _ = w.Write([]byte("hello"))
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if store.setCalled != testCase.expectedSet {
+				t.Errorf("expected store.Set to be called %d times, got %d",
+					testCase.expectedSet, store.setCalled)
+			}
+			// the Date header has a second precision
+			if d := testCase.expectedTTL - store.lastTTL; d < 0 || d > time.Second {
+				t.Errorf("expected ttl to be %s, got %s", testCase.expectedTTL, store.lastTTL)
+			}
+		})
+	}
+}
+
 func sameByteElements(a, b []byte) bool {
 	if len(a) != len(b) {
 		return false
@@ -188,3 +317,462 @@ func (rb *requestBuilder) build() *http.Request {
 	req.Header = rb.header
 	return req
 }
+
+func TestMiddlewareRequestCacheControl(t *testing.T) {
+	trustAll := func(r *http.Request) bool { return true }
+
+	testCases := []struct {
+		name           string
+		options        []Option
+		header         string
+		expectedCode   int
+		expectedCalled int
+	}{
+		{
+			name:           "no directives",
+			options:        []Option{WithRequestCacheControl(trustAll)},
+			expectedCode:   http.StatusOK,
+			expectedCalled: 1,
+		},
+		{
+			name:           "no-cache",
+			options:        []Option{WithRequestCacheControl(trustAll)},
+			header:         "no-cache",
+			expectedCode:   http.StatusOK,
+			expectedCalled: 2,
+		},
+		{
+			name:           "no-cache from untrusted request",
+			options:        []Option{WithRequestCacheControl(func(r *http.Request) bool { return false })},
+			header:         "no-cache",
+			expectedCode:   http.StatusOK,
+			expectedCalled: 1,
+		},
+		{
+			name:           "no-cache when disabled",
+			header:         "no-cache",
+			expectedCode:   http.StatusOK,
+			expectedCalled: 1,
+		},
+		{
+			name:           "min-fresh exceeding freshness",
+			options:        []Option{WithRequestCacheControl(trustAll)},
+			header:         "min-fresh=120",
+			expectedCode:   http.StatusOK,
+			expectedCalled: 2,
+		},
+		{
+			name:           "only-if-cached hit",
+			options:        []Option{WithRequestCacheControl(trustAll)},
+			header:         "only-if-cached",
+			expectedCode:   http.StatusOK,
+			expectedCalled: 1,
+		},
+		{
+			name:           "only-if-cached miss",
+			options:        []Option{WithRequestCacheControl(trustAll)},
+			header:         "only-if-cached, min-fresh=120",
+			expectedCode:   http.StatusGatewayTimeout,
+			expectedCalled: 1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			called := 0
+			mw, err := NewMiddleware(&testStore{}, testCase.options...)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called++
+				w.Header().Set("Cache-Control", "max-age=60")
+				_, _ = w.Write([]byte("hello"))
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+				withHeader("Cache-Control", testCase.header).build())
+
+			if rr.Code != testCase.expectedCode {
+				t.Errorf("expected %d status code, got %d", testCase.expectedCode, rr.Code)
+			}
+			if called != testCase.expectedCalled {
+				t.Errorf("expected handler to be called %d times, got %d", testCase.expectedCalled, called)
+			}
+		})
+	}
+}
+
+func Test_cachedResponse_satisfies(t *testing.T) {
+	now := time.Now()
+	fresh := cachedResponse{
+		Header:     http.Header{},
+		StoredAt:   now.Add(-10 * time.Second),
+		Date:       now.Add(-10 * time.Second),
+		FreshUntil: now.Add(50 * time.Second),
+	}
+	stale := cachedResponse{
+		Header:     http.Header{},
+		StoredAt:   now.Add(-90 * time.Second),
+		Date:       now.Add(-90 * time.Second),
+		FreshUntil: now.Add(-30 * time.Second),
+	}
+	mustRevalidate := stale
+	mustRevalidate.Header = http.Header{"Cache-Control": {"max-age=60, must-revalidate"}}
+
+	testCases := []struct {
+		name     string
+		cr       cachedResponse
+		header   string
+		expected bool
+	}{
+		{name: "fresh", cr: fresh, expected: true},
+		{name: "stale", cr: stale, expected: false},
+		{name: "max-age above age", cr: fresh, header: "max-age=20", expected: true},
+		{name: "max-age below age", cr: fresh, header: "max-age=5", expected: false},
+		{name: "min-fresh satisfied", cr: fresh, header: "min-fresh=40", expected: true},
+		{name: "min-fresh unsatisfied", cr: fresh, header: "min-fresh=60", expected: false},
+		{name: "max-stale without value", cr: stale, header: "max-stale", expected: true},
+		{name: "max-stale above staleness", cr: stale, header: "max-stale=60", expected: true},
+		{name: "max-stale below staleness", cr: stale, header: "max-stale=10", expected: false},
+		{name: "max-stale with must-revalidate", cr: mustRevalidate, header: "max-stale", expected: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			reqCC := parseCacheControl([]string{testCase.header})
+			if ok := testCase.cr.satisfies(reqCC, now); ok != testCase.expected {
+				t.Errorf("expected %v, got %v", testCase.expected, ok)
+			}
+		})
+	}
+}
+
+func TestMiddlewareVary(t *testing.T) {
+	called := 0
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+	})
+
+	store := &testStore{}
+	mw, err := NewMiddleware(store)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler = mw(handler)
+
+	for _, lang := range []string{"en", "de", "en", "de"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+			withHeader("Accept-Language", lang).build())
+
+		if body := rr.Body.String(); body != lang {
+			t.Errorf("expected body to be '%s', got '%s'", lang, body)
+		}
+	}
+
+	if called != 2 {
+		t.Errorf("expected handler to be called 2 times, got %d", called)
+	}
+}
+
+func TestMiddlewareVaryAsterisk(t *testing.T) {
+	store := &testStore{}
+	mw, err := NewMiddleware(store)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "*")
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if store.setCalled != 0 {
+		t.Errorf("expected store.Set not to be called, got %d", store.setCalled)
+	}
+}
+
+func TestMiddlewareConditionalRequest(t *testing.T) {
+	mw, err := NewMiddleware(&testStore{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+		withHeader("If-None-Match", `"v1"`).build())
+
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected %d status code, got %d", http.StatusNotModified, rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected empty body, got '%s'", rr.Body.String())
+	}
+	if etag := rr.Header().Get("ETag"); etag != `"v1"` {
+		t.Errorf("expected ETag to be '\"v1\"', got '%s'", etag)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "" {
+		t.Errorf("expected no Content-Type, got '%s'", ct)
+	}
+}
+
+func TestMiddlewareStatusPolicy(t *testing.T) {
+	testCases := []struct {
+		name        string
+		options     []Option
+		status      int
+		expectedSet int
+		expectedTTL time.Duration
+	}{
+		{name: "ok", status: http.StatusOK, expectedSet: 1, expectedTTL: time.Hour},
+		{name: "not found", status: http.StatusNotFound, expectedSet: 1, expectedTTL: defaultNegativeTTL},
+		{name: "partial content", status: http.StatusPartialContent, expectedSet: 0},
+		{name: "found", status: http.StatusFound, expectedSet: 0},
+		{name: "not modified", status: http.StatusNotModified, expectedSet: 0},
+		{name: "server error", status: http.StatusInternalServerError, expectedSet: 0},
+		{
+			name:        "status ttl",
+			options:     []Option{WithStatusTTL(http.StatusNotFound, 10*time.Minute)},
+			status:      http.StatusNotFound,
+			expectedSet: 1,
+			expectedTTL: 10 * time.Minute,
+		},
+		{
+			name:        "status ttl of non-default status",
+			options:     []Option{WithStatusTTL(http.StatusFound, 30*time.Second)},
+			status:      http.StatusFound,
+			expectedSet: 1,
+			expectedTTL: 30 * time.Second,
+		},
+		{
+			name:        "replaced statuses with error status",
+			options:     []Option{WithCacheableStatuses(http.StatusOK, http.StatusGone)},
+			status:      http.StatusGone,
+			expectedSet: 1,
+			expectedTTL: defaultNegativeTTL,
+		},
+		{
+			name:        "replaced statuses",
+			options:     []Option{WithCacheableStatuses(http.StatusCreated)},
+			status:      http.StatusOK,
+			expectedSet: 0,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			store := &testStore{}
+			options := append([]Option{WithTTL(time.Hour)}, testCase.options...)
+			mw, err := NewMiddleware(store, options...)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(testCase.status)
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if store.setCalled != testCase.expectedSet {
+				t.Errorf("expected store.Set to be called %d times, got %d",
+					testCase.expectedSet, store.setCalled)
+			}
+			if store.lastTTL != testCase.expectedTTL {
+				t.Errorf("expected ttl to be %s, got %s", testCase.expectedTTL, store.lastTTL)
+			}
+		})
+	}
+}
+
+func TestStatusOptions(t *testing.T) {
+	if _, err := NewMiddleware(&testStore{}, WithStatusTTL(http.StatusPartialContent, time.Minute)); err == nil {
+		t.Error("expected an error for 206")
+	}
+	if _, err := NewMiddleware(&testStore{}, WithCacheableStatuses(http.StatusNotModified)); err == nil {
+		t.Error("expected an error for 304")
+	}
+	if _, err := NewMiddleware(&testStore{}, WithStatusTTL(http.StatusNotFound, time.Minute)); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if ttl := defaultStatusTTLs[http.StatusNotFound]; ttl != defaultNegativeTTL {
+		t.Errorf("expected default status TTLs to stay intact, got %s", ttl)
+	}
+}
+
+func TestMiddlewareMaxBodySize(t *testing.T) {
+	store := &testStore{}
+	mw, err := NewMiddleware(store, WithMaxBodySize(5))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path[1:]))
+	}))
+
+	for _, path := range []string{"/hello", "/hello-world", "/hello-world"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+
+		if body := rr.Body.String(); body != path[1:] {
+			t.Errorf("expected body to be '%s', got '%s'", path[1:], body)
+		}
+	}
+
+	if store.setCalled != 1 {
+		t.Errorf("expected store.Set to be called 1 time, got %d", store.setCalled)
+	}
+	if _, err := NewMiddleware(store, WithMaxBodySize(0)); err == nil {
+		t.Error("expected error for zero size")
+	}
+}
+
+func TestMiddlewareStreamedResponse(t *testing.T) {
+	store := &testStore{}
+	mw, err := NewMiddleware(store)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("event: ping\n\n"))
+		w.(http.Flusher).Flush()
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !rr.Flushed {
+		t.Error("expected response to be flushed")
+	}
+	if store.setCalled != 0 {
+		t.Errorf("expected store.Set not to be called, got %d", store.setCalled)
+	}
+}
+
+func TestMiddlewareTrailer(t *testing.T) {
+	mw, err := NewMiddleware(&testStore{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	called := 0
+	server := httptest.NewServer(mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		w.Header().Set("Trailer", "X-Checksum")
+		_, _ = w.Write([]byte("hello"))
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Status", "0")
+	})))
+	defer server.Close()
+
+	expected := http.Header{"X-Checksum": {"abc"}, "X-Status": {"0"}}
+	for i := 0; i < 2; i++ {
+		res, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		if string(body) != "hello" {
+			t.Errorf("expected body to be 'hello', got '%s'", body)
+		}
+		if !reflect.DeepEqual(res.Trailer, expected) {
+			t.Errorf("expected trailer to be %v, got %v", expected, res.Trailer)
+		}
+		if v := res.Header.Get("X-Checksum"); v != "" {
+			t.Errorf("expected trailer not to be sent in the header, got '%s'", v)
+		}
+	}
+
+	if called != 1 {
+		t.Errorf("expected handler to be called 1 time, got %d", called)
+	}
+}
+
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingResponseWriter) Write(_ []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestMiddlewareIncompleteResponse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler func(w http.ResponseWriter, r *http.Request)
+		writer  http.ResponseWriter
+		panics  bool
+	}{
+		{
+			name: "panic",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("hel"))
+				panic(http.ErrAbortHandler)
+			},
+			panics: true,
+		},
+		{
+			name: "content-length mismatch",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "11")
+				_, _ = w.Write([]byte("hello"))
+			},
+		},
+		{
+			name: "invalid content-length",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "five")
+				_, _ = w.Write([]byte("hello"))
+			},
+		},
+		{
+			name: "write error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("hello"))
+			},
+			writer: failingResponseWriter{httptest.NewRecorder()},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			store := &testStore{}
+			mw, err := NewMiddleware(store)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(testCase.handler))
+
+			w := testCase.writer
+			if w == nil {
+				w = httptest.NewRecorder()
+			}
+			func() {
+				defer func() {
+					if p := recover(); (p != nil) != testCase.panics {
+						t.Errorf("expected panic to be propagated: %v, got %v", testCase.panics, p)
+					}
+				}()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			}()
+
+			if store.setCalled != 0 {
+				t.Errorf("expected store.Set not to be called, got %d", store.setCalled)
+			}
+		})
+	}
+}
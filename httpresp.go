@@ -1,9 +1,13 @@
 package httpcache
 
 import (
+	"bufio"
 	"bytes"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 type httpResponseRecorder struct {
@@ -14,6 +18,43 @@ type httpResponseRecorder struct {
 
 	wroteHeader bool
 	bodyWriter  io.Writer
+
+	// holdNotModified keeps 304 Not Modified responses from being written
+	// to respWriter, for them to be answered from the cache.
+	holdNotModified bool
+
+	// hold reports whether to keep the response with the status and header
+	// from being written to respWriter, for it to be answered from the
+	// recording, until the body exceeds maxBodySize. The recorded part is
+	// written then, and the rest of the body passed through.
+	hold    func(statusCode int, h http.Header) bool
+	holding bool
+
+	// discardBody keeps the body from being recorded, when only the status
+	// and header of the response are needed.
+	discardBody bool
+
+	// recordBody reports whether the body of the response with the status
+	// and header is needed, discardBody is set otherwise.
+	recordBody func(statusCode int, h http.Header) bool
+
+	// maxBodySize limits the size of the recorded body, if > 0. Once it's
+	// exceeded the body is dropped and only written to respWriter.
+	maxBodySize int64
+	bodyDropped bool
+
+	// flushed and hijacked are set when the handler streams the response
+	// or takes over the connection, such responses are not cacheable.
+	flushed  bool
+	hijacked bool
+
+	// failed is set when the handler panicked or the response couldn't be
+	// written, the recorded response may then be incomplete.
+	failed bool
+
+	// beforeWriteHeader is called with the header written to respWriter,
+	// for it to be amended without changing the recorded one.
+	beforeWriteHeader func(h http.Header, statusCode int)
 }
 
 func newHttpResponseRecorder(rw http.ResponseWriter) *httpResponseRecorder {
@@ -24,10 +65,35 @@ func (r *httpResponseRecorder) Write(buf []byte) (int, error) {
 	if !r.wroteHeader {
 		r.WriteHeader(200)
 	}
+	if r.holdNotModified && r.statusCode == http.StatusNotModified {
+		return len(buf), nil
+	}
 	if r.bodyWriter == nil {
-		r.bodyWriter = io.MultiWriter(r.respWriter, &r.body)
+		switch {
+		case r.discardBody:
+			r.bodyWriter = r.respWriter
+		case r.holding:
+			r.bodyWriter = &r.body
+		default:
+			r.bodyWriter = io.MultiWriter(r.respWriter, &r.body)
+		}
+	}
+	if r.maxBodySize > 0 && !r.bodyDropped && int64(r.body.Len()+len(buf)) > r.maxBodySize {
+		held, recorded := r.held(), r.body.Bytes()
+		r.dropBody()
+		if held {
+			r.writeHeader()
+			if _, err := r.respWriter.Write(recorded); err != nil {
+				r.failed = true
+				return 0, err
+			}
+		}
+	}
+	n, err := r.bodyWriter.Write(buf)
+	if err != nil {
+		r.failed = true
 	}
-	return r.bodyWriter.Write(buf)
+	return n, err
 }
 
 func (r *httpResponseRecorder) Header() http.Header {
@@ -44,6 +110,250 @@ func (r *httpResponseRecorder) WriteHeader(statusCode int) {
 
 	r.wroteHeader = true
 	r.statusCode = statusCode
-	copyHeader(r.respWriter.Header(), r.header)
-	r.respWriter.WriteHeader(statusCode)
+	if r.recordBody != nil && !r.recordBody(statusCode, r.Header()) {
+		r.discardBody = true
+	}
+	if r.maxBodySize > 0 && bodyAllowed(statusCode) {
+		if n, err := strconv.ParseInt(r.Header().Get("Content-Length"), 10, 64); err == nil && n > r.maxBodySize {
+			r.dropBody()
+		}
+	}
+	r.holding = r.hold != nil && !r.discardBody && r.hold(statusCode, r.Header())
+	if r.held() {
+		return
+	}
+	r.writeHeader()
+}
+
+// writeHeader writes the recorded header to respWriter.
+func (r *httpResponseRecorder) writeHeader() {
+	copyHeader(r.respWriter.Header(), r.Header())
+	if r.beforeWriteHeader != nil {
+		r.beforeWriteHeader(r.respWriter.Header(), r.statusCode)
+	}
+	r.respWriter.WriteHeader(r.statusCode)
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (r *httpResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.respWriter
+}
+
+// finish writes the header if the handler returned without writing
+// anything, and passes trailer fields set by the handler through.
+func (r *httpResponseRecorder) finish() {
+	if r.hijacked {
+		return
+	}
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.held() {
+		return
+	}
+	copyHeader(r.respWriter.Header(), r.trailer())
+}
+
+// trailer returns the trailer fields set by the handler, either declared in
+// the Trailer header or with the http.TrailerPrefix prefix. They're keyed as
+// set by the handler, so that they're sent the same way.
+func (r *httpResponseRecorder) trailer() http.Header {
+	declared := make(map[string]bool)
+	for _, v := range r.header.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				declared[http.CanonicalHeaderKey(k)] = true
+			}
+		}
+	}
+
+	var trailer http.Header
+	for k, v := range r.header {
+		if !declared[k] && !strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
+		if trailer == nil {
+			trailer = make(http.Header)
+		}
+		trailer[k] = v
+	}
+	return trailer
+}
+
+// dropBody releases the recorded body and stops recording it, the response
+// is then not cacheable.
+func (r *httpResponseRecorder) dropBody() {
+	r.bodyDropped = true
+	r.body = bytes.Buffer{}
+	r.bodyWriter = r.respWriter
+}
+
+// storable reports whether the whole response was recorded. The body must
+// have the length declared by the Content-Length header, if any.
+func (r *httpResponseRecorder) storable() bool {
+	if r.bodyDropped || r.discardBody || r.flushed || r.hijacked || r.failed {
+		return false
+	}
+	if !bodyAllowed(r.statusCode) {
+		return true
+	}
+	if cl := r.header.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		return err == nil && n == int64(r.body.Len())
+	}
+	return true
+}
+
+// held reports whether the response is kept from respWriter, for it to be
+// answered from the recording.
+func (r *httpResponseRecorder) held() bool {
+	if r.holdNotModified && r.statusCode == http.StatusNotModified {
+		return true
+	}
+	return r.holding && !r.bodyDropped && !r.hijacked
+}
+
+// holdAlways holds responses whatever their status and header.
+func holdAlways(_ int, _ http.Header) bool {
+	return true
+}
+
+// responseWriter returns the recorder as a writer implementing the optional
+// interfaces http.Flusher, http.Hijacker and io.ReaderFrom implemented by
+// the underlying writer, for the handler to detect them.
+func (r *httpResponseRecorder) responseWriter() http.ResponseWriter {
+	_, isFlusher := r.respWriter.(http.Flusher)
+	_, isHijacker := r.respWriter.(http.Hijacker)
+	_, isReaderFrom := r.respWriter.(io.ReaderFrom)
+	f, h, rf := recorderFlusher{r}, recorderHijacker{r}, recorderReaderFrom{r}
+
+	switch {
+	case isFlusher && isHijacker && isReaderFrom:
+		return struct {
+			*httpResponseRecorder
+			recorderFlusher
+			recorderHijacker
+			recorderReaderFrom
+		}{r, f, h, rf}
+	case isFlusher && isHijacker:
+		return struct {
+			*httpResponseRecorder
+			recorderFlusher
+			recorderHijacker
+		}{r, f, h}
+	case isFlusher && isReaderFrom:
+		return struct {
+			*httpResponseRecorder
+			recorderFlusher
+			recorderReaderFrom
+		}{r, f, rf}
+	case isHijacker && isReaderFrom:
+		return struct {
+			*httpResponseRecorder
+			recorderHijacker
+			recorderReaderFrom
+		}{r, h, rf}
+	case isFlusher:
+		return struct {
+			*httpResponseRecorder
+			recorderFlusher
+		}{r, f}
+	case isHijacker:
+		return struct {
+			*httpResponseRecorder
+			recorderHijacker
+		}{r, h}
+	case isReaderFrom:
+		return struct {
+			*httpResponseRecorder
+			recorderReaderFrom
+		}{r, rf}
+	}
+	return r
+}
+
+type recorderFlusher struct {
+	r *httpResponseRecorder
+}
+
+// Flush sends the buffered response to the client, the response is then
+// streamed and not cacheable.
+func (f recorderFlusher) Flush() {
+	f.r.flushed = true
+	if !f.r.wroteHeader {
+		f.r.WriteHeader(http.StatusOK)
+	}
+	if f.r.held() {
+		return
+	}
+	f.r.respWriter.(http.Flusher).Flush()
+}
+
+type recorderHijacker struct {
+	r *httpResponseRecorder
+}
+
+// Hijack lets the handler take over the connection, the response is then
+// not cacheable.
+func (h recorderHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.r.respWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		h.r.hijacked = true
+	}
+	return conn, rw, err
+}
+
+type recorderReaderFrom struct {
+	r *httpResponseRecorder
+}
+
+// ReadFrom copies src to the response. The underlying writer is used
+// directly, e.g. for sendfile, unless the body is recorded.
+func (rf recorderReaderFrom) ReadFrom(src io.Reader) (int64, error) {
+	r := rf.r
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	var (
+		n   int64
+		err error
+	)
+	if r.held() || !r.discardBody && !r.bodyDropped {
+		n, err = io.Copy(r, src)
+	} else {
+		n, err = r.respWriter.(io.ReaderFrom).ReadFrom(src)
+	}
+	if err != nil {
+		r.failed = true
+	}
+	return n, err
+}
+
+// discardResponseWriter is used to run the handler in the background, it
+// keeps the header and drops everything else.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(_ int) {}
+
+// headResponseWriter answers a HEAD request with the response to a GET
+// request, dropping the body.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(buf []byte) (int, error) {
+	return len(buf), nil
 }
@@ -1,7 +1,13 @@
 package httpcache
 
 import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -62,3 +68,285 @@ func Test_httpResponseRecorder_Write(t *testing.T) {
 		t.Error("expected Body to be equal")
 	}
 }
+
+func Test_httpResponseRecorder_HoldNotModified(t *testing.T) {
+	testRr := httptest.NewRecorder()
+	rr := newHttpResponseRecorder(testRr)
+	rr.holdNotModified = true
+	rr.Header().Set("foo", "bar")
+	rr.WriteHeader(304)
+
+	if testRr.Code != 200 || testRr.Header().Get("foo") != "" {
+		t.Error("expected 304 response not to be written")
+	}
+	if rr.statusCode != 304 {
+		t.Errorf("expected code to be 304, got %d", rr.statusCode)
+	}
+}
+
+func Test_httpResponseRecorder_Finish(t *testing.T) {
+	testRr := httptest.NewRecorder()
+	rr := newHttpResponseRecorder(testRr)
+	rr.Header().Set("foo", "bar")
+	rr.finish()
+
+	if rr.statusCode != 200 {
+		t.Errorf("expected code to be 200, got %d", rr.statusCode)
+	}
+	if testRr.Header().Get("foo") != "bar" {
+		t.Errorf("expected header 'foo' to be 'bar', got %s", testRr.Header().Get("foo"))
+	}
+}
+
+func Test_httpResponseRecorder_MaxBodySize(t *testing.T) {
+	testRr := httptest.NewRecorder()
+	rr := newHttpResponseRecorder(testRr)
+	rr.maxBodySize = 5
+	_, _ = rr.Write([]byte("hel"))
+	_, _ = rr.Write([]byte("lo"))
+
+	if rr.bodyDropped || rr.body.String() != "hello" {
+		t.Errorf("expected body within the limit to be recorded, got '%s'", rr.body.String())
+	}
+
+	_, _ = rr.Write([]byte(" world"))
+
+	if !rr.bodyDropped || rr.body.Len() != 0 {
+		t.Errorf("expected body to be dropped, got '%s'", rr.body.String())
+	}
+	if body := testRr.Body.String(); body != "hello world" {
+		t.Errorf("expected body to be 'hello world', got '%s'", body)
+	}
+}
+
+func Test_httpResponseRecorder_MaxBodySizeContentLength(t *testing.T) {
+	testRr := httptest.NewRecorder()
+	rr := newHttpResponseRecorder(testRr)
+	rr.maxBodySize = 5
+	rr.Header().Set("Content-Length", "11")
+	_, _ = rr.Write([]byte("hel"))
+
+	if !rr.bodyDropped || rr.body.Len() != 0 {
+		t.Errorf("expected body not to be recorded, got '%s'", rr.body.String())
+	}
+	if body := testRr.Body.String(); body != "hel" {
+		t.Errorf("expected body to be 'hel', got '%s'", body)
+	}
+}
+
+func Test_httpResponseRecorder_HoldResponse(t *testing.T) {
+	testRr := httptest.NewRecorder()
+	rr := newHttpResponseRecorder(testRr)
+	rr.hold = holdAlways
+	rr.maxBodySize = 5
+	rr.Header().Set("foo", "bar")
+	_, _ = rr.Write([]byte("hello"))
+
+	if !rr.held() || testRr.Body.Len() != 0 || testRr.Header().Get("foo") != "" {
+		t.Errorf("expected response to be held, got '%s'", testRr.Body.String())
+	}
+
+	_, _ = rr.Write([]byte(" world"))
+
+	if rr.held() || !rr.bodyDropped {
+		t.Error("expected response to be released")
+	}
+	if testRr.Header().Get("foo") != "bar" {
+		t.Errorf("expected header 'foo' to be 'bar', got '%s'", testRr.Header().Get("foo"))
+	}
+	if body := testRr.Body.String(); body != "hello world" {
+		t.Errorf("expected body to be 'hello world', got '%s'", body)
+	}
+}
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.hijacked = true
+	return nil, nil, nil
+}
+
+type fullRecorder struct {
+	*hijackRecorder
+}
+
+func (f fullRecorder) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(f.ResponseRecorder, src)
+}
+
+type readerFromRecorder struct {
+	http.ResponseWriter
+	readFrom int64
+}
+
+func (rf *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	n, err := io.Copy(rf.ResponseWriter, src)
+	rf.readFrom += n
+	return n, err
+}
+
+func Test_httpResponseRecorder_responseWriter(t *testing.T) {
+	testCases := []struct {
+		name         string
+		rw           http.ResponseWriter
+		isFlusher    bool
+		isHijacker   bool
+		isReaderFrom bool
+	}{
+		{name: "discard", rw: &discardResponseWriter{}},
+		{name: "flusher", rw: httptest.NewRecorder(), isFlusher: true},
+		{name: "hijacker", rw: &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}, isFlusher: true, isHijacker: true},
+		{name: "reader from", rw: &readerFromRecorder{ResponseWriter: &discardResponseWriter{}}, isReaderFrom: true},
+		{name: "all", rw: fullRecorder{&hijackRecorder{ResponseRecorder: httptest.NewRecorder()}},
+			isFlusher: true, isHijacker: true, isReaderFrom: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rr := newHttpResponseRecorder(testCase.rw)
+			w := rr.responseWriter()
+
+			if _, ok := w.(http.Flusher); ok != testCase.isFlusher {
+				t.Errorf("expected http.Flusher to be implemented: %v", testCase.isFlusher)
+			}
+			if _, ok := w.(http.Hijacker); ok != testCase.isHijacker {
+				t.Errorf("expected http.Hijacker to be implemented: %v", testCase.isHijacker)
+			}
+			if _, ok := w.(io.ReaderFrom); ok != testCase.isReaderFrom {
+				t.Errorf("expected io.ReaderFrom to be implemented: %v", testCase.isReaderFrom)
+			}
+			if u, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok || u.Unwrap() != testCase.rw {
+				t.Error("expected Unwrap to return the underlying writer")
+			}
+		})
+	}
+}
+
+func Test_httpResponseRecorder_Flush(t *testing.T) {
+	testRr := httptest.NewRecorder()
+	rr := newHttpResponseRecorder(testRr)
+	_, _ = rr.Write([]byte("hello"))
+	rr.responseWriter().(http.Flusher).Flush()
+
+	if !testRr.Flushed {
+		t.Error("expected underlying writer to be flushed")
+	}
+	if rr.storable() {
+		t.Error("expected flushed response not to be storable")
+	}
+}
+
+func Test_httpResponseRecorder_Hijack(t *testing.T) {
+	hr := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	rr := newHttpResponseRecorder(hr)
+	if _, _, err := rr.responseWriter().(http.Hijacker).Hijack(); err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	rr.finish()
+
+	if !hr.hijacked {
+		t.Error("expected underlying writer to be hijacked")
+	}
+	if rr.wroteHeader {
+		t.Error("expected header not to be written on a hijacked connection")
+	}
+	if rr.storable() {
+		t.Error("expected hijacked response not to be storable")
+	}
+}
+
+func Test_httpResponseRecorder_ReadFrom(t *testing.T) {
+	t.Run("recorded", func(t *testing.T) {
+		rf := &readerFromRecorder{ResponseWriter: httptest.NewRecorder()}
+		rr := newHttpResponseRecorder(rf)
+		n, err := rr.responseWriter().(io.ReaderFrom).ReadFrom(strings.NewReader("hello"))
+		if err != nil || n != 5 {
+			t.Fatalf("expected 5 bytes to be copied, got %d, %v", n, err)
+		}
+		if rr.body.String() != "hello" {
+			t.Errorf("expected body to be recorded, got '%s'", rr.body.String())
+		}
+		if rf.readFrom != 0 {
+			t.Error("expected underlying ReadFrom not to be used")
+		}
+	})
+
+	t.Run("dropped", func(t *testing.T) {
+		rf := &readerFromRecorder{ResponseWriter: httptest.NewRecorder()}
+		rr := newHttpResponseRecorder(rf)
+		rr.maxBodySize = 2
+		rr.Header().Set("Content-Length", "5")
+		n, err := rr.responseWriter().(io.ReaderFrom).ReadFrom(strings.NewReader("hello"))
+		if err != nil || n != 5 {
+			t.Fatalf("expected 5 bytes to be copied, got %d, %v", n, err)
+		}
+		if rf.readFrom != 5 {
+			t.Errorf("expected underlying ReadFrom to be used, got %d bytes", rf.readFrom)
+		}
+	})
+}
+
+func Test_httpResponseRecorder_Trailer(t *testing.T) {
+	testRr := httptest.NewRecorder()
+	rr := newHttpResponseRecorder(testRr)
+	rr.Header().Set("Trailer", "X-Checksum, x-status")
+	_, _ = rr.Write([]byte("hello"))
+	rr.Header().Set("X-Checksum", "abc")
+	rr.Header().Set("X-Status", "0")
+	rr.Header().Set(http.TrailerPrefix+"X-Extra", "1")
+	rr.Header().Set("X-Late", "foo")
+	rr.finish()
+
+	expected := http.Header{
+		"X-Checksum":                   {"abc"},
+		"X-Status":                     {"0"},
+		http.TrailerPrefix + "X-Extra": {"1"},
+	}
+	if trailer := rr.trailer(); !reflect.DeepEqual(trailer, expected) {
+		t.Errorf("expected trailer to be %v, got %v", expected, trailer)
+	}
+
+	expected = http.Header{"X-Checksum": {"abc"}, "X-Status": {"0"}, "X-Extra": {"1"}}
+	if trailer := testRr.Result().Trailer; !reflect.DeepEqual(trailer, expected) {
+		t.Errorf("expected trailer to be passed through as %v, got %v", expected, trailer)
+	}
+}
+
+func Test_httpResponseRecorder_storable(t *testing.T) {
+	testCases := []struct {
+		name          string
+		status        int
+		contentLength string
+		body          string
+		failed        bool
+		expected      bool
+	}{
+		{name: "complete", status: 200, body: "hello", expected: true},
+		{name: "content-length", status: 200, contentLength: "5", body: "hello", expected: true},
+		{name: "short body", status: 200, contentLength: "11", body: "hello", expected: false},
+		{name: "invalid content-length", status: 200, contentLength: "-", body: "hello", expected: false},
+		{name: "no body allowed", status: 204, contentLength: "11", expected: true},
+		{name: "failed", status: 200, body: "hello", failed: true, expected: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			rr := newHttpResponseRecorder(httptest.NewRecorder())
+			if testCase.contentLength != "" {
+				rr.Header().Set("Content-Length", testCase.contentLength)
+			}
+			rr.WriteHeader(testCase.status)
+			if testCase.body != "" {
+				_, _ = rr.Write([]byte(testCase.body))
+			}
+			rr.failed = testCase.failed
+
+			if got := rr.storable(); got != testCase.expected {
+				t.Errorf("expected %v, got %v", testCase.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// safeMethods are the request methods which don't change the state of the
+// server (RFC 9110, section 9.2.1).
+var safeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// invalidateAfter handles the response to a request with an unsafe method.
+// If the handler succeeded, responses stored for the target URI and
+// same-origin URIs of the Location and Content-Location header fields are
+// invalidated (RFC 9111, section 4.4).
+func (m middleware) invalidateAfter(r *http.Request, rec *httpResponseRecorder) {
+	if rec.statusCode < 200 || rec.statusCode > 399 {
+		return
+	}
+
+	ctx := detachedContext{r.Context()}
+	m.invalidate(ctx, r.URL)
+	for _, k := range []string{"Location", "Content-Location"} {
+		if u, ok := sameOriginURL(r, rec.Header().Get(k)); ok {
+			m.invalidate(ctx, u)
+		}
+	}
+}
+
+// invalidate removes the response stored for GET requests of the URL. With
+// a Vary header, removing the primary entry makes all variants unreachable.
+func (m middleware) invalidate(ctx context.Context, u *url.URL) {
+	if err := m.store.Delete(ctx, m.generateKey(u)); err != nil {
+		m.onError(fmt.Errorf("failed to invalidate %s: %v", u, err))
+	}
+}
+
+// sameOriginURL resolves the URI reference against the request URL. It
+// reports whether the URI has the same origin as the request, and returns
+// it in the form of the request URL, for it to produce the same key.
+func sameOriginURL(r *http.Request, ref string) (*url.URL, bool) {
+	if ref == "" {
+		return nil, false
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := *r.URL
+	base.Scheme, base.Host = scheme, r.Host
+
+	u, err := base.Parse(ref)
+	if err != nil || u.Scheme != scheme || !strings.EqualFold(u.Host, r.Host) {
+		return nil, false
+	}
+
+	u.Fragment, u.RawFragment, u.User = "", "", nil
+	if r.URL.Host == "" {
+		u.Scheme, u.Host = "", ""
+	}
+	return u, true
+}
@@ -0,0 +1,123 @@
+package httpcache
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareInvalidation(t *testing.T) {
+	testCases := []struct {
+		name            string
+		method          string
+		status          int
+		location        string
+		contentLocation string
+		expectedCalls   map[string]int
+	}{
+		{name: "post", method: http.MethodPost, status: http.StatusOK,
+			expectedCalls: map[string]int{"/a": 2, "/b": 1}},
+		{name: "put", method: http.MethodPut, status: http.StatusNoContent,
+			expectedCalls: map[string]int{"/a": 2, "/b": 1}},
+		{name: "delete redirect", method: http.MethodDelete, status: http.StatusSeeOther,
+			expectedCalls: map[string]int{"/a": 2, "/b": 1}},
+		{name: "error", method: http.MethodPatch, status: http.StatusInternalServerError,
+			expectedCalls: map[string]int{"/a": 1, "/b": 1}},
+		{name: "options", method: http.MethodOptions, status: http.StatusOK,
+			expectedCalls: map[string]int{"/a": 1, "/b": 1}},
+		{name: "location", method: http.MethodPost, status: http.StatusCreated, location: "/b",
+			expectedCalls: map[string]int{"/a": 2, "/b": 2}},
+		{name: "relative content location", method: http.MethodPut, status: http.StatusOK, contentLocation: "b",
+			expectedCalls: map[string]int{"/a": 2, "/b": 2}},
+		{name: "absolute location", method: http.MethodPost, status: http.StatusCreated,
+			location:      "http://example.com/b",
+			expectedCalls: map[string]int{"/a": 2, "/b": 2}},
+		{name: "cross-origin location", method: http.MethodPost, status: http.StatusCreated,
+			location:      "http://other.example.com/b",
+			expectedCalls: map[string]int{"/a": 2, "/b": 1}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			calls := make(map[string]int)
+			mw, err := NewMiddleware(&testStore{})
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet {
+					if testCase.location != "" {
+						w.Header().Set("Location", testCase.location)
+					}
+					if testCase.contentLocation != "" {
+						w.Header().Set("Content-Location", testCase.contentLocation)
+					}
+					w.WriteHeader(testCase.status)
+					return
+				}
+				calls[r.URL.Path]++
+				_, _ = w.Write([]byte("hello"))
+			}))
+
+			for _, path := range []string{"/a", "/b"} {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(testCase.method, "/a", nil))
+			if rr.Code != testCase.status {
+				t.Errorf("expected %d status code, got %d", testCase.status, rr.Code)
+			}
+
+			for _, path := range []string{"/a", "/b"} {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+			}
+
+			for path, expected := range testCase.expectedCalls {
+				if calls[path] != expected {
+					t.Errorf("expected handler to be called %d times for %s, got %d", expected, path, calls[path])
+				}
+			}
+		})
+	}
+}
+
+func Test_sameOriginURL(t *testing.T) {
+	testCases := []struct {
+		name     string
+		target   string
+		tls      bool
+		ref      string
+		expected string
+		ok       bool
+	}{
+		{name: "empty", target: "/a", ref: "", ok: false},
+		{name: "absolute path", target: "/a/b", ref: "/c?x=1", expected: "/c?x=1", ok: true},
+		{name: "relative path", target: "/a/b", ref: "c", expected: "/a/c", ok: true},
+		{name: "same origin", target: "/a", ref: "http://EXAMPLE.com/c#top", expected: "/c", ok: true},
+		{name: "other host", target: "/a", ref: "http://example.org/c", ok: false},
+		{name: "other scheme", target: "/a", ref: "https://example.com/c", ok: false},
+		{name: "tls", target: "/a", tls: true, ref: "https://example.com/c", expected: "/c", ok: true},
+		{name: "absolute request", target: "http://example.com/a", ref: "/c",
+			expected: "http://example.com/c", ok: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, testCase.target, nil)
+			r.Host = "example.com"
+			if testCase.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+
+			u, ok := sameOriginURL(r, testCase.ref)
+			if ok != testCase.ok {
+				t.Fatalf("expected %v, got %v", testCase.ok, ok)
+			}
+			if ok && u.String() != testCase.expected {
+				t.Errorf("expected '%s', got '%s'", testCase.expected, u.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package httpcache
+
+import "net/http"
+
+// SetCookiePolicy defines how responses with a Set-Cookie header are stored.
+type SetCookiePolicy int
+
+const (
+	// SetCookieSkip keeps responses setting cookies from being stored.
+	SetCookieSkip SetCookiePolicy = iota
+	// SetCookieStrip stores responses without their Set-Cookie header. The
+	// cookies are only sent in the response passed through from the handler.
+	SetCookieStrip
+)
+
+// admit reports whether the response to r may be stored and sets its
+// expiration. Header fields which must not be stored are removed from res.
+func (m middleware) admit(r *http.Request, res *cachedResponse) bool {
+	m.selectCacheControl(res)
+	if r.Header.Get("Authorization") != "" && !allowsAuthorized(res.cacheControl()) {
+		return false
+	}
+
+	if len(res.Header.Values("Set-Cookie")) > 0 && m.setCookiePolicy == SetCookieSkip {
+		return false
+	}
+	var removed bool
+	unstored := append([]string{"Set-Cookie"}, m.unstoredHeaders...)
+	for _, k := range append(unstored, m.targetedFields...) {
+		if _, ok := res.Header[k]; !ok {
+			continue
+		}
+		if !removed { // keep the header of the response served intact
+			res.Header = res.Header.Clone()
+			removed = true
+		}
+		delete(res.Header, k)
+	}
+
+	return m.setExpiration(r, res)
+}
+
+// allowsAuthorized reports whether a response to a request with an
+// Authorization header may be stored by a shared cache (RFC 9111, section
+// 3.5).
+func allowsAuthorized(cc cacheControl) bool {
+	return cc.has("public") || cc.has("s-maxage") || cc.has("must-revalidate")
+}
@@ -0,0 +1,106 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewarePrivacy(t *testing.T) {
+	testCases := []struct {
+		name           string
+		options        []Option
+		authorization  string
+		header         http.Header
+		expectedCalled int
+		expectedHeader http.Header
+	}{
+		{
+			name:           "authorization",
+			authorization:  "Bearer foo",
+			header:         http.Header{"Cache-Control": {"max-age=60"}},
+			expectedCalled: 2,
+		},
+		{
+			name:           "authorization public",
+			authorization:  "Bearer foo",
+			header:         http.Header{"Cache-Control": {"public, max-age=60"}},
+			expectedCalled: 1,
+		},
+		{
+			name:           "authorization s-maxage",
+			authorization:  "Bearer foo",
+			header:         http.Header{"Cache-Control": {"s-maxage=60"}},
+			expectedCalled: 1,
+		},
+		{
+			name:           "set-cookie",
+			header:         http.Header{"Set-Cookie": {"session=foo"}},
+			expectedCalled: 2,
+			expectedHeader: http.Header{"Set-Cookie": {"session=foo"}},
+		},
+		{
+			name:           "set-cookie strip",
+			options:        []Option{WithSetCookiePolicy(SetCookieStrip)},
+			header:         http.Header{"Set-Cookie": {"session=foo"}, "Content-Type": {"text/plain"}},
+			expectedCalled: 1,
+			expectedHeader: http.Header{"Set-Cookie": nil, "Content-Type": {"text/plain"}},
+		},
+		{
+			name:           "unstored headers",
+			options:        []Option{WithUnstoredHeaders("x-debug")},
+			header:         http.Header{"X-Debug": {"foo"}, "Content-Type": {"text/plain"}},
+			expectedCalled: 1,
+			expectedHeader: http.Header{"X-Debug": nil, "Content-Type": {"text/plain"}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			called := 0
+			mw, err := NewMiddleware(&testStore{}, testCase.options...)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called++
+				copyHeader(w.Header(), testCase.header)
+				_, _ = w.Write([]byte("hello"))
+			}))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+				withHeader("Authorization", testCase.authorization).build())
+			for k, v := range testCase.header {
+				if got := rr.Header()[k]; len(got) != len(v) {
+					t.Errorf("expected %s header to be passed through, got %v", k, got)
+				}
+			}
+
+			rr = httptest.NewRecorder()
+			handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+				withHeader("Authorization", testCase.authorization).build())
+
+			if called != testCase.expectedCalled {
+				t.Errorf("expected handler to be called %d times, got %d", testCase.expectedCalled, called)
+			}
+			for k, v := range testCase.expectedHeader {
+				if got := rr.Header()[k]; len(got) != len(v) {
+					t.Errorf("expected %s header to be %v, got %v", k, v, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPrivacyOptions(t *testing.T) {
+	if _, err := NewMiddleware(&testStore{}, WithSetCookiePolicy(SetCookiePolicy(5))); err == nil {
+		t.Error("expected error for invalid policy")
+	}
+	if _, err := NewMiddleware(&testStore{}, WithUnstoredHeaders()); err == nil {
+		t.Error("expected error for empty headers")
+	}
+	if _, err := NewMiddleware(&testStore{}, WithUnstoredHeaders("")); err == nil {
+		t.Error("expected error for empty header")
+	}
+}
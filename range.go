@@ -0,0 +1,315 @@
+package httpcache
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteRange is a range of a representation, with an inclusive end.
+type byteRange struct {
+	start, end int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.end, size)
+}
+
+// isRangeRequest reports whether r asks for a part of the representation.
+// The Range header is ignored for methods other than GET.
+func isRangeRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.Header.Get("Range") != ""
+}
+
+// fillRange fills the cache on a range request miss. The handler is run
+// with a request for the full representation, and only the requested range
+// of its body is sent as it's written. Responses which can't be answered
+// that way are held, and the requested ranges served from the recording. If
+// the body of a held response exceeds the maximum size, the full response
+// is passed through as soon as it does.
+func (m middleware) fillRange(w http.ResponseWriter, r *http.Request, key uint64, reqCC cacheControl, cs cacheStatus) (cachedResponse, bool) {
+	req := unconditionalRequest(r)
+	rec := m.newRangeRecorder(w, r, cs)
+	m.serve(rec, req)
+
+	res, ok := m.saveRecorded(req, key, reqCC, rec)
+	if rec.held() {
+		cs.fwdStatus = rec.statusCode
+		m.serveCachedResponse(w, r, newCachedResponse(rec, time.Now()), cs)
+	}
+	return res, ok
+}
+
+// newRangeRecorder returns a recorder for the response to a request for the
+// full representation, answering the range request r from it as the body is
+// written. Responses with several ranges requested or without a known size
+// are held, as the parts can't be sent as they're written.
+func (m middleware) newRangeRecorder(w http.ResponseWriter, r *http.Request, cs cacheStatus) *httpResponseRecorder {
+	rw := &rangeWriter{ResponseWriter: w, r: r}
+	rec := m.newRecorder(rw, r, cs)
+	rec.hold = rw.hold
+	return rec
+}
+
+// rangeWriter answers a range request with the response to a request for
+// the full representation, sending only the requested range of the body as
+// it's written. Responses without a single range to send are passed through
+// as is, unless the range can't be satisfied.
+type rangeWriter struct {
+	http.ResponseWriter
+	r *http.Request
+
+	// br is the range sent, offset the offset in the full body of the next
+	// byte written.
+	br      byteRange
+	offset  int64
+	sliced  bool
+	discard bool
+}
+
+// ranges returns the ranges of the response with the status and header
+// requested, and the size of the representation. It returns false if the
+// Range header must be ignored or the size isn't known.
+func (rw *rangeWriter) ranges(statusCode int, h http.Header) ([]byteRange, int64, bool) {
+	if statusCode != http.StatusOK || !ifRangeMatches(rw.r.Header.Get("If-Range"), h) {
+		return nil, 0, false
+	}
+	size, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+	if err != nil || size < 0 {
+		return nil, 0, false
+	}
+	ranges, ok := parseRange(rw.r.Header.Get("Range"), size)
+	return ranges, size, ok
+}
+
+// hold reports whether the response must be held for the requested ranges
+// to be served from the recording.
+func (rw *rangeWriter) hold(statusCode int, h http.Header) bool {
+	if statusCode != http.StatusOK || !ifRangeMatches(rw.r.Header.Get("If-Range"), h) {
+		return false
+	}
+	if h.Get("Content-Length") == "" {
+		return true
+	}
+	ranges, _, ok := rw.ranges(statusCode, h)
+	return ok && len(ranges) > 1
+}
+
+func (rw *rangeWriter) WriteHeader(statusCode int) {
+	h := rw.Header()
+	ranges, size, ok := rw.ranges(statusCode, h)
+	switch {
+	case !ok || len(ranges) > 1:
+	case len(ranges) == 0:
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		statusCode = http.StatusRequestedRangeNotSatisfiable
+		rw.discard = true
+	default:
+		rw.br, rw.sliced = ranges[0], true
+		h.Set("Content-Range", rw.br.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(rw.br.end-rw.br.start+1, 10))
+		statusCode = http.StatusPartialContent
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write sends the part of buf within the requested range, if any.
+func (rw *rangeWriter) Write(buf []byte) (int, error) {
+	if rw.discard {
+		return len(buf), nil
+	}
+	if !rw.sliced {
+		return rw.ResponseWriter.Write(buf)
+	}
+
+	offset := rw.offset
+	rw.offset += int64(len(buf))
+	start, end := rw.br.start-offset, rw.br.end+1-offset
+	if start < 0 {
+		start = 0
+	}
+	if end > int64(len(buf)) {
+		end = int64(len(buf))
+	}
+	if start < end {
+		if _, err := rw.ResponseWriter.Write(buf[start:end]); err != nil {
+			return 0, err
+		}
+	}
+	return len(buf), nil
+}
+
+// serveRange answers a range request with the parts of the stored response,
+// whose header is already copied to w. It returns false if the Range header
+// must be ignored and the full response served instead.
+func (m middleware) serveRange(w http.ResponseWriter, r *http.Request, cr cachedResponse) bool {
+	if !ifRangeMatches(r.Header.Get("If-Range"), cr.Header) {
+		return false
+	}
+	size := int64(len(cr.Body))
+	ranges, ok := parseRange(r.Header.Get("Range"), size)
+	if !ok {
+		return false
+	}
+
+	h := w.Header()
+	switch len(ranges) {
+	case 0:
+		h.Del("Content-Type")
+		h.Del("Content-Length")
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	case 1:
+		br := ranges[0]
+		h.Set("Content-Range", br.contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(br.end-br.start+1, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if _, err := w.Write(cr.Body[br.start : br.end+1]); err != nil {
+			m.onError(err)
+		}
+		return true
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, br := range ranges {
+		ph := make(textproto.MIMEHeader)
+		if ct := cr.Header.Get("Content-Type"); ct != "" {
+			ph.Set("Content-Type", ct)
+		}
+		ph.Set("Content-Range", br.contentRange(size))
+		part, err := mw.CreatePart(ph)
+		if err != nil {
+			m.onError(err)
+			return false
+		}
+		_, _ = part.Write(cr.Body[br.start : br.end+1]) // writes to a bytes.Buffer don't fail
+	}
+	if err := mw.Close(); err != nil {
+		m.onError(err)
+		return false
+	}
+
+	h.Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	h.Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(http.StatusPartialContent)
+	if _, err := w.Write(body.Bytes()); err != nil {
+		m.onError(err)
+	}
+	return true
+}
+
+// ifRangeMatches evaluates the If-Range header value against the stored
+// response header (RFC 9110, section 13.1.5). Entity tags are compared
+// with the strong comparison, dates must match Last-Modified exactly.
+func ifRangeMatches(ifRange string, h http.Header) bool {
+	if ifRange == "" {
+		return true
+	}
+	if tag, rest := scanETag(ifRange); tag != "" {
+		return rest == "" && etagMatch(tag, h.Get("ETag"), false)
+	}
+
+	date, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(h.Get("Last-Modified"))
+	return err == nil && date.Equal(lastModified)
+}
+
+// parseRange parses the Range header value for a representation of the size
+// (RFC 9110, section 14.2). Ranges which can't be satisfied are dropped,
+// none being left means the whole request can't be satisfied. It returns
+// false if the header must be ignored, because it's invalid, uses another
+// unit, or asks for more bytes than the representation has.
+func parseRange(s string, size int64) ([]byteRange, bool) {
+	const unit = "bytes="
+	if len(s) < len(unit) || !strings.EqualFold(s[:len(unit)], unit) {
+		return nil, false
+	}
+
+	var (
+		ranges []byteRange
+		specs  int
+		total  int64
+	)
+	for _, spec := range strings.Split(s[len(unit):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		specs++
+		i := strings.IndexByte(spec, '-')
+		if i < 0 {
+			return nil, false
+		}
+		first, last := spec[:i], spec[i+1:]
+
+		var br byteRange
+		if first == "" { // suffix range
+			n, ok := parseDigits(last)
+			if !ok {
+				return nil, false
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			br = byteRange{start: size - n, end: size - 1}
+		} else {
+			start, ok := parseDigits(first)
+			if !ok {
+				return nil, false
+			}
+			end := size - 1
+			if last != "" {
+				if end, ok = parseDigits(last); !ok || end < start {
+					return nil, false
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			br = byteRange{start: start, end: end}
+		}
+
+		ranges = append(ranges, br)
+		total += br.end - br.start + 1
+	}
+
+	if specs == 0 {
+		return nil, false
+	}
+	if total > size { // overlapping ranges, serve the representation once
+		return nil, false
+	}
+	return ranges, true
+}
+
+// parseDigits parses a non-negative decimal integer made of digits only.
+func parseDigits(s string) (int64, bool) {
+	if s == "" {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
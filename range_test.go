@@ -0,0 +1,368 @@
+package httpcache
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_parseRange(t *testing.T) {
+	testCases := []struct {
+		value    string
+		size     int64
+		expected []byteRange
+		ok       bool
+	}{
+		{value: "bytes=0-4", size: 10, expected: []byteRange{{0, 4}}, ok: true},
+		{value: "Bytes=5-", size: 10, expected: []byteRange{{5, 9}}, ok: true},
+		{value: "bytes=-3", size: 10, expected: []byteRange{{7, 9}}, ok: true},
+		{value: "bytes=-30", size: 10, expected: []byteRange{{0, 9}}, ok: true},
+		{value: "bytes=8-20", size: 10, expected: []byteRange{{8, 9}}, ok: true},
+		{value: "bytes=0-1, 4-5,,", size: 10, expected: []byteRange{{0, 1}, {4, 5}}, ok: true},
+		{value: "bytes=0-1,20-30", size: 10, expected: []byteRange{{0, 1}}, ok: true},
+		{value: "bytes=10-", size: 10, expected: nil, ok: true},
+		{value: "bytes=-0", size: 10, expected: nil, ok: true},
+		{value: "bytes=0-", size: 0, expected: nil, ok: true},
+		{value: "bytes=0-9,0-9", size: 10, ok: false},
+		{value: "bytes=5-4", size: 10, ok: false},
+		{value: "bytes=a-4", size: 10, ok: false},
+		{value: "bytes=+1-4", size: 10, ok: false},
+		{value: "bytes=4", size: 10, ok: false},
+		{value: "bytes=", size: 10, ok: false},
+		{value: "items=0-4", size: 10, ok: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.value, func(t *testing.T) {
+			ranges, ok := parseRange(testCase.value, testCase.size)
+			if ok != testCase.ok {
+				t.Fatalf("expected %v, got %v", testCase.ok, ok)
+			}
+			if !reflect.DeepEqual(ranges, testCase.expected) {
+				t.Errorf("expected %v, got %v", testCase.expected, ranges)
+			}
+		})
+	}
+}
+
+func Test_ifRangeMatches(t *testing.T) {
+	h := http.Header{
+		"Etag":          {`"v1"`},
+		"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"},
+	}
+
+	testCases := []struct {
+		ifRange  string
+		expected bool
+	}{
+		{ifRange: "", expected: true},
+		{ifRange: `"v1"`, expected: true},
+		{ifRange: `"v2"`, expected: false},
+		{ifRange: `W/"v1"`, expected: false},
+		{ifRange: "Mon, 02 Jan 2006 15:04:05 GMT", expected: true},
+		{ifRange: "Mon, 02 Jan 2006 15:04:06 GMT", expected: false},
+		{ifRange: "foo", expected: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.ifRange, func(t *testing.T) {
+			if got := ifRangeMatches(testCase.ifRange, h); got != testCase.expected {
+				t.Errorf("expected %v, got %v", testCase.expected, got)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRange(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		header               map[string]string
+		expectedCode         int
+		expectedBody         string
+		expectedContentRange string
+	}{
+		{
+			name:                 "single range",
+			header:               map[string]string{"Range": "bytes=0-4"},
+			expectedCode:         http.StatusPartialContent,
+			expectedBody:         "hello",
+			expectedContentRange: "bytes 0-4/11",
+		},
+		{
+			name:                 "suffix range",
+			header:               map[string]string{"Range": "bytes=-5"},
+			expectedCode:         http.StatusPartialContent,
+			expectedBody:         "world",
+			expectedContentRange: "bytes 6-10/11",
+		},
+		{
+			name:                 "unsatisfiable",
+			header:               map[string]string{"Range": "bytes=20-"},
+			expectedCode:         http.StatusRequestedRangeNotSatisfiable,
+			expectedContentRange: "bytes */11",
+		},
+		{
+			name:         "invalid",
+			header:       map[string]string{"Range": "bytes=4-0"},
+			expectedCode: http.StatusOK,
+			expectedBody: "hello world",
+		},
+		{
+			name:                 "if-range match",
+			header:               map[string]string{"Range": "bytes=0-4", "If-Range": `"v1"`},
+			expectedCode:         http.StatusPartialContent,
+			expectedBody:         "hello",
+			expectedContentRange: "bytes 0-4/11",
+		},
+		{
+			name:         "if-range mismatch",
+			header:       map[string]string{"Range": "bytes=0-4", "If-Range": `"v0"`},
+			expectedCode: http.StatusOK,
+			expectedBody: "hello world",
+		},
+	}
+
+	for _, testCase := range testCases {
+		for _, streamed := range []bool{false, true} {
+			name := testCase.name
+			if streamed {
+				name += " streamed"
+			}
+			t.Run(name, func(t *testing.T) {
+				var ranges []string
+				mw, err := NewMiddleware(&testStore{})
+				if err != nil {
+					t.Fatal("unexpected error", err)
+				}
+				handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					ranges = append(ranges, r.Header.Get("Range"))
+					w.Header().Set("ETag", `"v1"`)
+					w.Header().Set("Content-Type", "text/plain")
+					if streamed {
+						w.Header().Set("Content-Length", "11")
+					}
+					_, _ = w.Write([]byte("hello world"))
+				}))
+
+				for i := 0; i < 2; i++ { // miss, then hit
+					rb := newRequestBuilder().withMethod(http.MethodGet).withPath("/")
+					for k, v := range testCase.header {
+						rb.withHeader(k, v)
+					}
+					rr := httptest.NewRecorder()
+					handler.ServeHTTP(rr, rb.build())
+
+					if rr.Code != testCase.expectedCode {
+						t.Errorf("expected %d status code, got %d", testCase.expectedCode, rr.Code)
+					}
+					if body := rr.Body.String(); body != testCase.expectedBody {
+						t.Errorf("expected body to be '%s', got '%s'", testCase.expectedBody, body)
+					}
+					if cr := rr.Header().Get("Content-Range"); cr != testCase.expectedContentRange {
+						t.Errorf("expected Content-Range to be '%s', got '%s'", testCase.expectedContentRange, cr)
+					}
+				}
+
+				if !reflect.DeepEqual(ranges, []string{""}) {
+					t.Errorf("expected handler to be called once for the full representation, got %q", ranges)
+				}
+			})
+		}
+	}
+}
+
+func TestMiddlewareMultipartRange(t *testing.T) {
+	mw, err := NewMiddleware(&testStore{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("hello world"))
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+		withHeader("Range", "bytes=0-1,-2").build())
+
+	if rr.Code != http.StatusPartialContent {
+		t.Fatalf("expected %d status code, got %d", http.StatusPartialContent, rr.Code)
+	}
+	mediaType, params, err := mime.ParseMediaType(rr.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("expected multipart/byteranges, got '%s'", rr.Header().Get("Content-Type"))
+	}
+
+	expected := []struct{ contentRange, body string }{
+		{"bytes 0-1/11", "he"},
+		{"bytes 9-10/11", "ld"},
+	}
+	mr := multipart.NewReader(rr.Body, params["boundary"])
+	for _, e := range expected {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if cr := part.Header.Get("Content-Range"); cr != e.contentRange {
+			t.Errorf("expected Content-Range to be '%s', got '%s'", e.contentRange, cr)
+		}
+		if ct := part.Header.Get("Content-Type"); ct != "text/plain" {
+			t.Errorf("expected Content-Type to be 'text/plain', got '%s'", ct)
+		}
+		if body, _ := io.ReadAll(part); string(body) != e.body {
+			t.Errorf("expected part body to be '%s', got '%s'", e.body, body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expected no more parts, got %v", err)
+	}
+}
+
+func TestMiddlewareRangeRevalidation(t *testing.T) {
+	testCases := []struct {
+		name         string
+		cacheControl string
+		changingETag bool
+	}{
+		{name: "not modified", cacheControl: "max-age=0"},
+		{name: "modified", cacheControl: "max-age=0", changingETag: true},
+		{name: "background refresh", cacheControl: "max-age=0, stale-while-revalidate=60"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var (
+				mutex  sync.Mutex
+				ranges []string
+			)
+			store := &testStore{}
+			mw, err := NewMiddleware(store)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				ranges = append(ranges, r.Header.Get("Range"))
+				etag := `"v1"`
+				if testCase.changingETag {
+					etag = `"v` + strconv.Itoa(len(ranges)) + `"`
+				}
+				mutex.Unlock()
+
+				w.Header().Set("Cache-Control", testCase.cacheControl)
+				w.Header().Set("ETag", etag)
+				http.ServeContent(w, r, "", time.Time{}, strings.NewReader("hello world"))
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+				withHeader("Range", "bytes=0-4").build())
+			handler.(*middleware).refreshes.wait()
+
+			if rr.Code != http.StatusPartialContent || rr.Body.String() != "hello" {
+				t.Errorf("expected range 'hello', got %d '%s'", rr.Code, rr.Body.String())
+			}
+			mutex.Lock()
+			if !reflect.DeepEqual(ranges, []string{"", ""}) {
+				t.Errorf("expected handler to be called for the full representation, got %q", ranges)
+			}
+			mutex.Unlock()
+			if store.setCalled != 2 {
+				t.Errorf("expected the entry to be refreshed, store.Set called %d times", store.setCalled)
+			}
+		})
+	}
+}
+
+func TestMiddlewarePartialContentNotStored(t *testing.T) {
+	store := &testStore{}
+	mw, err := NewMiddleware(store)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Range", "bytes 0-4/11")
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+		withHeader("Range", "bytes=0-4").build())
+
+	if rr.Code != http.StatusPartialContent {
+		t.Errorf("expected %d status code, got %d", http.StatusPartialContent, rr.Code)
+	}
+	if store.setCalled != 0 {
+		t.Errorf("expected store.Set not to be called, got %d", store.setCalled)
+	}
+}
+
+func TestMiddlewareRangeMaxBodySize(t *testing.T) {
+	var ranges []string
+	store := &testStore{}
+	mw, err := NewMiddleware(store, WithMaxBodySize(5))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("hello world"))
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+		withHeader("Range", "bytes=0-1").build())
+
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != "he" {
+		t.Errorf("expected range 'he', got %d '%s'", rr.Code, rr.Body.String())
+	}
+	if !reflect.DeepEqual(ranges, []string{""}) {
+		t.Errorf("expected handler to be called once for the full representation, got %q", ranges)
+	}
+	if store.setCalled != 0 {
+		t.Errorf("expected store.Set not to be called, got %d", store.setCalled)
+	}
+}
+
+func TestMiddlewareRangeStreaming(t *testing.T) {
+	store := &testStore{}
+	mw, err := NewMiddleware(store)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Length", "11")
+		for _, chunk := range []string{"hel", "lo wo", "rld"} {
+			_, _ = w.Write([]byte(chunk))
+		}
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+		withHeader("Range", "bytes=4-8").build())
+
+	if rr.Code != http.StatusPartialContent || rr.Body.String() != "o wor" {
+		t.Errorf("expected range 'o wor', got %d '%s'", rr.Code, rr.Body.String())
+	}
+	if cr := rr.Header().Get("Content-Range"); cr != "bytes 4-8/11" {
+		t.Errorf("expected Content-Range to be 'bytes 4-8/11', got '%s'", cr)
+	}
+	if cl := rr.Header().Get("Content-Length"); cl != "5" {
+		t.Errorf("expected Content-Length to be '5', got '%s'", cl)
+	}
+	if store.setCalled != 0 {
+		t.Errorf("expected store.Set not to be called, got %d", store.setCalled)
+	}
+}
@@ -0,0 +1,104 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// refreshGroup runs background refreshes, at most one per key and no more
+// than the configured number at once.
+type refreshGroup struct {
+	mutex    sync.Mutex
+	inFlight map[uint64]struct{}
+	sem      chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newRefreshGroup(limit int) *refreshGroup {
+	return &refreshGroup{
+		inFlight: make(map[uint64]struct{}),
+		sem:      make(chan struct{}, limit),
+	}
+}
+
+// start runs f in a goroutine unless a refresh of key is already in flight
+// or the limit is reached. It reports whether f was started.
+func (g *refreshGroup) start(key uint64, f func()) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if _, ok := g.inFlight[key]; ok {
+		return false
+	}
+	select {
+	case g.sem <- struct{}{}:
+	default:
+		return false
+	}
+	g.inFlight[key] = struct{}{}
+	g.wg.Add(1)
+
+	go func() {
+		defer func() {
+			g.mutex.Lock()
+			delete(g.inFlight, key)
+			g.mutex.Unlock()
+			<-g.sem
+			g.wg.Done()
+		}()
+		f()
+	}()
+	return true
+}
+
+// wait blocks until all started refreshes are done.
+func (g *refreshGroup) wait() {
+	g.wg.Wait()
+}
+
+// refreshInBackground revalidates the stored response to r without
+// blocking the request. The handler is run with a copy of r which isn't
+// canceled when the client goes away.
+func (m middleware) refreshInBackground(r *http.Request, key uint64, cr cachedResponse) {
+	m.refreshes.start(key, func() {
+		ctx, cancel := context.WithTimeout(detachedContext{r.Context()}, m.refreshTimeout)
+		defer cancel()
+
+		defer func() {
+			if p := recover(); p != nil {
+				m.onError(fmt.Errorf("background refresh panicked: %v", p))
+			}
+		}()
+
+		req := revalidationRequest(r.WithContext(ctx), cr.Header)
+		rec := m.bufferRecorder()
+		m.serve(rec, req)
+
+		m.revalidated(req, key, nil, cr, rec)
+	})
+}
+
+// detachedContext keeps values of the parent context, but is never
+// canceled and has no deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
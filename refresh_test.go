@@ -0,0 +1,132 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareStaleWhileRevalidate(t *testing.T) {
+	var version int32
+	mw, err := NewMiddleware(&testStore{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.Context().Err(); err != nil {
+			t.Errorf("expected context not to be canceled, got %s", err)
+		}
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		_, _ = w.Write([]byte{byte('0' + atomic.AddInt32(&version, 1))})
+	}))
+
+	serve := func() string {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+		return rr.Body.String()
+	}
+
+	if body := serve(); body != "1" {
+		t.Errorf("expected body to be '1', got '%s'", body)
+	}
+	if body := serve(); body != "1" {
+		t.Errorf("expected stale body '1', got '%s'", body)
+	}
+	handler.(*middleware).refreshes.wait()
+
+	if body := serve(); body != "2" {
+		t.Errorf("expected refreshed body '2', got '%s'", body)
+	}
+	handler.(*middleware).refreshes.wait()
+
+	if v := atomic.LoadInt32(&version); v != 3 {
+		t.Errorf("expected handler to be called 3 times, got %d", v)
+	}
+}
+
+func TestMiddlewareStaleWhileRevalidateBody(t *testing.T) {
+	var calls int32
+	mw, err := NewMiddleware(&testStore{}, WithCacheableRequests(func(r *http.Request) bool { return r.Method == http.MethodPost }))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("unexpected error reading the body: %v", err)
+		}
+		if string(body) != "query" {
+			t.Errorf("expected body 'query', got '%s'", body)
+		}
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		_, _ = w.Write([]byte("hello"))
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(server.URL, "text/plain", strings.NewReader("query"))
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+	handler.(*middleware).refreshes.wait()
+
+	if c := atomic.LoadInt32(&calls); c != 2 {
+		t.Errorf("expected handler to be called 2 times, got %d", c)
+	}
+}
+
+func Test_refreshGroup(t *testing.T) {
+	g := newRefreshGroup(2)
+	release := make(chan struct{})
+	block := func() { <-release }
+
+	if !g.start(1, block) {
+		t.Error("expected refresh of key 1 to start")
+	}
+	if g.start(1, block) {
+		t.Error("expected duplicate refresh of key 1 not to start")
+	}
+	if !g.start(2, block) {
+		t.Error("expected refresh of key 2 to start")
+	}
+	if g.start(3, block) {
+		t.Error("expected refresh of key 3 not to start over the limit")
+	}
+
+	close(release)
+	g.wait()
+
+	if !g.start(1, func() {}) {
+		t.Error("expected refresh of key 1 to start again")
+	}
+	g.wait()
+}
+
+func Test_detachedContext(t *testing.T) {
+	type ctxKey struct{}
+	parent, cancel := context.WithTimeout(context.WithValue(context.Background(), ctxKey{}, "foo"), time.Millisecond)
+	cancel()
+
+	ctx := detachedContext{parent}
+	if ctx.Err() != nil {
+		t.Errorf("expected no error, got %s", ctx.Err())
+	}
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("expected no deadline")
+	}
+	if v := ctx.Value(ctxKey{}); v != "foo" {
+		t.Errorf("expected value to be 'foo', got %v", v)
+	}
+}
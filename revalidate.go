@@ -0,0 +1,127 @@
+package httpcache
+
+import (
+	"net/http"
+	"time"
+)
+
+// conditionalHeaders are the request header fields making a request
+// conditional (RFC 9110, section 13.1).
+var conditionalHeaders = []string{
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"If-Range",
+}
+
+// revalidate validates the stored response with the handler using a
+// conditional request. If the handler answers 304 Not Modified, the stored
+// response is refreshed and served, otherwise the new response is passed
+// through and stored in place of the old one. On a range request, the
+// requested range of the new response is sent. It returns the stored
+// response and whether it's cacheable.
+func (m middleware) revalidate(w http.ResponseWriter, r *http.Request, key uint64, reqCC cacheControl, cr cachedResponse, cs cacheStatus) (cachedResponse, bool) {
+	req := revalidationRequest(r, cr.Header)
+	var rec *httpResponseRecorder
+	if isRangeRequest(r) {
+		rec = m.newRangeRecorder(w, r, cs)
+	} else {
+		rec = m.newRecorder(w, r, cs)
+	}
+	rec.holdNotModified = true
+	m.serve(rec, req)
+
+	served, res, ok := m.revalidated(req, key, reqCC, cr, rec)
+	if rec.held() {
+		cs.fwdStatus = rec.statusCode
+		m.serveCachedResponse(w, r, served, cs)
+	}
+	return res, ok
+}
+
+// revalidated stores the handler response to a revalidation request of cr,
+// which is refreshed if the handler answered 304 Not Modified. It returns the
+// response to serve, along with the stored one and whether it's cacheable.
+func (m middleware) revalidated(r *http.Request, key uint64, reqCC cacheControl, cr cachedResponse, rec *httpResponseRecorder) (cachedResponse, cachedResponse, bool) {
+	now := time.Now()
+	if rec.statusCode != http.StatusNotModified {
+		res, ok := m.saveRecorded(r, key, reqCC, rec)
+		return newCachedResponse(rec, now), res, ok
+	}
+
+	cr = cr.updated(rec.Header(), now)
+	res := cr
+	if reqCC.has("no-store") || !m.admit(r, &res) {
+		return cr, cachedResponse{}, false
+	}
+	if err := m.storeResponse(r.Context(), key, r, res); err != nil {
+		m.onError(err)
+	}
+	cr.FreshUntil, cr.KeepUntil = res.FreshUntil, res.KeepUntil
+	return cr, res, true
+}
+
+// revalidationRequest returns a copy of r with preconditions replaced
+// by validators of the stored response.
+func revalidationRequest(r *http.Request, h http.Header) *http.Request {
+	req := unconditionalRequest(r)
+	if etag := h.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := h.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return req
+}
+
+// unconditionalRequest returns a copy of r without preconditions, for the
+// full representation. HEAD requests are turned into GET ones, as HEAD
+// responses are stored as GET, and the Range header is removed, ranges
+// being served from the stored response. The copy reads the body anew if r
+// allows it.
+func unconditionalRequest(r *http.Request) *http.Request {
+	req := r.Clone(r.Context())
+	if r.GetBody != nil {
+		if body, err := r.GetBody(); err == nil {
+			req.Body = body
+		}
+	}
+	if req.Method == http.MethodHead {
+		req.Method = http.MethodGet
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	for _, k := range conditionalHeaders {
+		req.Header.Del(k)
+	}
+	req.Header.Del("Range")
+	return req
+}
+
+func (cr cachedResponse) hasValidators() bool {
+	return cr.Header.Get("ETag") != "" || cr.Header.Get("Last-Modified") != ""
+}
+
+// updated returns the stored response freshened with header fields of a 304
+// Not Modified response received at now (RFC 9111, section 4.3.4).
+func (cr cachedResponse) updated(h http.Header, now time.Time) cachedResponse {
+	header := cr.Header.Clone()
+	header.Del("Date")
+	skip := hopByHopFields(h)
+	for k, v := range h {
+		if k == "Content-Length" || skip[k] {
+			continue
+		}
+		header[k] = v
+	}
+
+	cr.Header = header
+	cr.StoredAt = now
+	cr.Date = responseDate(header, now)
+	if header.Get("Date") == "" {
+		header.Set("Date", cr.Date.UTC().Format(http.TimeFormat))
+	}
+	return cr
+}
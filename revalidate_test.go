@@ -0,0 +1,98 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareRevalidation(t *testing.T) {
+	testCases := []struct {
+		name         string
+		etag         string
+		expectedBody string
+		expectedSet  int
+	}{
+		{name: "not modified", etag: `"v1"`, expectedBody: "v1", expectedSet: 2},
+		{name: "modified", etag: `"v2"`, expectedBody: "v2", expectedSet: 2},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			etag := `"v1"`
+			var conditions []string
+			store := &testStore{}
+			mw, err := NewMiddleware(store)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conditions = append(conditions, r.Header.Get("If-None-Match"))
+				w.Header().Set("Cache-Control", "max-age=0")
+				w.Header().Set("ETag", etag)
+				if r.Header.Get("If-None-Match") == etag {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				_, _ = w.Write([]byte(etag[1:3]))
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			etag = testCase.etag
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("expected %d status code, got %d", http.StatusOK, rr.Code)
+			}
+			if body := rr.Body.String(); body != testCase.expectedBody {
+				t.Errorf("expected body to be '%s', got '%s'", testCase.expectedBody, body)
+			}
+			if len(conditions) != 2 || conditions[0] != "" || conditions[1] != `"v1"` {
+				t.Errorf("expected handler to be revalidated with '\"v1\"', got %v", conditions)
+			}
+			if store.setCalled != testCase.expectedSet {
+				t.Errorf("expected store.Set to be called %d times, got %d",
+					testCase.expectedSet, store.setCalled)
+			}
+		})
+	}
+}
+
+func Test_cachedResponse_updated(t *testing.T) {
+	storedAt := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	now := storedAt.Add(time.Hour)
+	cr := cachedResponse{
+		Header: http.Header{
+			"Cache-Control":  {"max-age=0"},
+			"Content-Length": {"5"},
+			"Content-Type":   {"text/plain"},
+			"Date":           {storedAt.Format(http.TimeFormat)},
+		},
+		StoredAt: storedAt,
+		Date:     storedAt,
+	}
+
+	updated := cr.updated(http.Header{
+		"Cache-Control":  {"max-age=60"},
+		"Content-Length": {"0"},
+	}, now)
+
+	if cc := updated.Header.Get("Cache-Control"); cc != "max-age=60" {
+		t.Errorf("expected Cache-Control to be updated, got '%s'", cc)
+	}
+	if cl := updated.Header.Get("Content-Length"); cl != "5" {
+		t.Errorf("expected Content-Length to be kept, got '%s'", cl)
+	}
+	if ct := updated.Header.Get("Content-Type"); ct != "text/plain" {
+		t.Errorf("expected Content-Type to be kept, got '%s'", ct)
+	}
+	if !updated.StoredAt.Equal(now) || !updated.Date.Equal(now) {
+		t.Errorf("expected times to be updated to %s, got %s and %s", now, updated.StoredAt, updated.Date)
+	}
+	if cr.Header.Get("Cache-Control") != "max-age=0" {
+		t.Error("expected original header to stay intact")
+	}
+}
@@ -0,0 +1,194 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// staleWhileRevalidate reports whether the stale response may be served
+// while it's refreshed in the background (RFC 5861, section 3).
+func (cr cachedResponse) staleWhileRevalidate(reqCC cacheControl, now time.Time) bool {
+	cc, ok := cr.allowsStale(reqCC)
+	if !ok {
+		return false
+	}
+	window, ok := cc.seconds("stale-while-revalidate")
+	return ok && now.Before(cr.FreshUntil.Add(window))
+}
+
+// staleIfError reports whether the stale response may be served if the
+// handler fails to refresh it (RFC 5861, section 4).
+func (cr cachedResponse) staleIfError(reqCC cacheControl, now time.Time, defaultWindow time.Duration) bool {
+	cc, ok := cr.allowsStale(reqCC)
+	if !ok {
+		return false
+	}
+	window := staleIfErrorWindow(cc, defaultWindow)
+	return window > 0 && now.Before(cr.FreshUntil.Add(window))
+}
+
+// allowsStale returns Cache-Control directives of the response and reports
+// whether neither the response nor the request forbid serving it stale. The
+// stale-* directives are given precedence over proxy-revalidate implied by
+// s-maxage.
+func (cr cachedResponse) allowsStale(reqCC cacheControl) (cacheControl, bool) {
+	if reqCC.has("no-cache") || reqCC.has("max-age") || reqCC.has("min-fresh") {
+		return nil, false
+	}
+	cc := cr.cacheControl()
+	if cc.has("must-revalidate") || cc.has("proxy-revalidate") {
+		return nil, false
+	}
+	return cc, true
+}
+
+func staleIfErrorWindow(cc cacheControl, defaultWindow time.Duration) time.Duration {
+	if window, ok := cc.seconds("stale-if-error"); ok {
+		return window
+	}
+	return defaultWindow
+}
+
+// refreshOrServeStale refreshes the stale response like revalidate does,
+// but holds the handler response so that the stale one can be served
+// instead if the handler fails. If the body exceeds the maximum size, the
+// response is passed through as soon as it does, unless it's a server
+// error. It returns the stored response and whether it's cacheable.
+func (m middleware) refreshOrServeStale(w http.ResponseWriter, r *http.Request, key uint64, reqCC cacheControl, cr cachedResponse, cs cacheStatus) (cachedResponse, bool) {
+	req := revalidationRequest(r, cr.Header)
+	rw := &refreshWriter{w: w}
+	rec := m.newRecorder(rw, r, cs)
+	rec.hold, rec.holdNotModified = holdAlways, true
+
+	err := m.serveWithTimeout(rec, req, rw)
+	if rw.passed() {
+		if err != nil {
+			m.onError(err)
+			panic(http.ErrAbortHandler)
+		}
+		return cachedResponse{}, false
+	}
+	if err == nil && rec.statusCode >= 500 {
+		err = fmt.Errorf("handler responded with %d", rec.statusCode)
+		cs.fwdStatus = rec.statusCode
+	}
+	if err != nil {
+		m.onError(err)
+		cs.fromCache, cs.detail = true, "stale-if-error"
+		m.serveCachedResponse(w, r, cr.withWarning(`111 - "Revalidation Failed"`), cs)
+		return cachedResponse{}, false
+	}
+
+	cs.fwdStatus = rec.statusCode
+	served, res, ok := m.revalidated(req, key, reqCC, cr, rec)
+	m.serveCachedResponse(w, r, served, cs)
+	return res, ok
+}
+
+// serveWithTimeout runs the handler with the configured timeout, turning
+// panics and timeouts into errors. On timeout the handler is left running
+// and rec must not be used, unless the response is already passed through
+// by rw: the handler is waited for then.
+func (m middleware) serveWithTimeout(rec *httpResponseRecorder, r *http.Request, rw *refreshWriter) error {
+	if m.handlerTimeout <= 0 {
+		return m.serveRecovered(rec, r)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), m.handlerTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- m.serveRecovered(rec, r.WithContext(ctx))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if !rw.detach() {
+			return <-done
+		}
+		return fmt.Errorf("handler timed out: %w", ctx.Err())
+	}
+}
+
+func (m middleware) serveRecovered(rec *httpResponseRecorder, r *http.Request) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("handler panicked: %v", p)
+		}
+	}()
+
+	m.serve(rec, r)
+	return nil
+}
+
+// withWarning returns a copy of the response with a Warning header added.
+func (cr cachedResponse) withWarning(warning string) cachedResponse {
+	cr.Header = cr.Header.Clone()
+	cr.Header.Add("Warning", warning)
+	return cr
+}
+
+// refreshWriter passes the response refreshing a stale one through to w,
+// unless it's a server error. Once detached, because the handler timed out,
+// nothing is written to w anymore, for the stale response to be served.
+type refreshWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mutex    sync.Mutex
+	wrote    bool
+	detached bool
+}
+
+func (rw *refreshWriter) Header() http.Header {
+	if rw.header == nil {
+		rw.header = make(http.Header)
+	}
+	return rw.header
+}
+
+func (rw *refreshWriter) WriteHeader(statusCode int) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	if rw.detached || statusCode >= 500 {
+		return
+	}
+	copyHeader(rw.w.Header(), rw.header)
+	rw.w.WriteHeader(statusCode)
+	rw.wrote = true
+}
+
+func (rw *refreshWriter) Write(buf []byte) (int, error) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	if !rw.wrote {
+		return len(buf), nil
+	}
+	return rw.w.Write(buf)
+}
+
+// detach stops passing the response through. It returns false if the
+// response is already passed through.
+func (rw *refreshWriter) detach() bool {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	rw.detached = !rw.wrote
+	return rw.detached
+}
+
+// passed reports whether the response is passed through to w.
+func (rw *refreshWriter) passed() bool {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	return rw.wrote
+}
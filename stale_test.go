@@ -0,0 +1,175 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareStaleIfError(t *testing.T) {
+	testCases := []struct {
+		name         string
+		options      []Option
+		cacheControl string
+		fail         func(w http.ResponseWriter, r *http.Request)
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "server error",
+			cacheControl: "max-age=0, stale-if-error=60",
+			fail: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: "ok",
+		},
+		{
+			name:         "panic",
+			cacheControl: "max-age=0, stale-if-error=60",
+			fail: func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: "ok",
+		},
+		{
+			name:         "timeout",
+			options:      []Option{WithHandlerTimeout(10 * time.Millisecond)},
+			cacheControl: "max-age=0, stale-if-error=60",
+			fail: func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: "ok",
+		},
+		{
+			name:         "configured window",
+			options:      []Option{WithStaleIfError(time.Minute)},
+			cacheControl: "max-age=0",
+			fail: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: "ok",
+		},
+		{
+			name:         "must-revalidate",
+			cacheControl: "max-age=0, must-revalidate, stale-if-error=60",
+			fail: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			expectedCode: http.StatusInternalServerError,
+		},
+		{
+			name:         "success",
+			cacheControl: "max-age=0, stale-if-error=60",
+			fail: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("new"))
+			},
+			expectedCode: http.StatusOK,
+			expectedBody: "new",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase // the handler may outlive the iteration on timeout
+		t.Run(testCase.name, func(t *testing.T) {
+			failing := false
+			var errs []error
+			options := append([]Option{WithOnErrorFunc(func(err error) { errs = append(errs, err) })}, testCase.options...)
+			mw, err := NewMiddleware(&testStore{}, options...)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if failing {
+					testCase.fail(w, r)
+					return
+				}
+				w.Header().Set("Cache-Control", testCase.cacheControl)
+				_, _ = w.Write([]byte("ok"))
+			}))
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			failing = true
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rr.Code != testCase.expectedCode {
+				t.Errorf("expected %d status code, got %d", testCase.expectedCode, rr.Code)
+			}
+			if body := rr.Body.String(); body != testCase.expectedBody {
+				t.Errorf("expected body to be '%s', got '%s'", testCase.expectedBody, body)
+			}
+			stale := testCase.expectedBody == "ok"
+			if hasWarning := rr.Header().Get("Warning") != ""; hasWarning != stale {
+				t.Errorf("expected Warning header presence to be %v", stale)
+			}
+			if hasError := len(errs) > 0; hasError != stale {
+				t.Errorf("expected error to be reported: %v, got %v", stale, errs)
+			}
+		})
+	}
+}
+
+func TestMiddlewareStaleIfErrorMaxBodySize(t *testing.T) {
+	var calls int
+	mw, err := NewMiddleware(&testStore{}, WithStaleIfError(time.Minute), WithMaxBodySize(5))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "max-age=0")
+		if calls == 1 {
+			_, _ = w.Write([]byte("hello"))
+			return
+		}
+		_, _ = w.Write([]byte("hello world"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if body := rr.Body.String(); body != "hello world" {
+		t.Errorf("expected body 'hello world', got '%s'", body)
+	}
+	if calls != 2 {
+		t.Errorf("expected handler to be called 2 times, got %d", calls)
+	}
+}
+
+func TestMiddlewareStaleIfErrorMaxBodySizeServerError(t *testing.T) {
+	var calls int
+	mw, err := NewMiddleware(&testStore{}, WithStaleIfError(time.Minute), WithMaxBodySize(5))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "max-age=0")
+		if calls == 1 {
+			_, _ = w.Write([]byte("hello"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("internal server error"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "hello" {
+		t.Errorf("expected stale response, got %d '%s'", rr.Code, rr.Body.String())
+	}
+	if calls != 2 {
+		t.Errorf("expected handler to be called 2 times, got %d", calls)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strconv"
 	"sync"
 	"time"
 
@@ -16,6 +17,11 @@ type item struct {
 	alNode  *accessListNode
 }
 
+type lock struct {
+	token   string
+	expires time.Time
+}
+
 type accessList struct {
 	head, tail *accessListNode
 }
@@ -85,6 +91,8 @@ type Store struct {
 	capacityBytes int
 	data          map[uint64]item
 	al            *accessList
+	locks         map[uint64]lock
+	lockSeq       uint64
 }
 
 // NewStore initializes memory store.
@@ -101,6 +109,7 @@ func NewStore(opts ...Option) (*Store, error) {
 		data:          make(map[uint64]item),
 		capacityBytes: options.capacityBytes,
 		al:            &accessList{},
+		locks:         make(map[uint64]lock),
 	}, nil
 }
 
@@ -170,6 +179,52 @@ func (s *Store) Set(_ context.Context, key uint64, data []byte, ttl time.Duratio
 	return nil
 }
 
+// Delete removes data
+func (s *Store) Delete(_ context.Context, key uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+
+	s.al.remove(i.alNode)
+	s.sizeBytes -= len(i.data)
+	delete(s.data, key)
+
+	return nil
+}
+
+// Lock acquires the fill lock of key for ttl, if it's not held already.
+func (s *Store) Lock(_ context.Context, key uint64, ttl time.Duration) (string, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if l, ok := s.locks[key]; ok && l.expires.After(now) {
+		return "", false, nil
+	}
+
+	s.lockSeq++
+	token := strconv.FormatUint(s.lockSeq, 10)
+	s.locks[key] = lock{token: token, expires: now.Add(ttl)}
+
+	return token, true, nil
+}
+
+// Unlock releases the fill lock of key if it's held with token.
+func (s *Store) Unlock(_ context.Context, key uint64, token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if l, ok := s.locks[key]; ok && l.token == token {
+		delete(s.locks, key)
+	}
+
+	return nil
+}
+
 func (s *Store) capacityLeftBytes() int {
 	return s.capacityBytes - s.sizeBytes
 }
@@ -203,4 +258,7 @@ func WithCapacity(bytes int) Option {
 	}
 }
 
-var _ httpcache.Store = (*Store)(nil)
+var (
+	_ httpcache.Store  = (*Store)(nil)
+	_ httpcache.Locker = (*Store)(nil)
+)
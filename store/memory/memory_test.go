@@ -225,3 +225,76 @@ func TestStoreEviction(t *testing.T) {
 		}
 	})
 }
+
+func TestStoreLock(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewStore()
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	token, ok, err := store.Lock(ctx, uint64(1), time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected lock to be acquired, got %v, %v", ok, err)
+	}
+	if _, ok, _ := store.Lock(ctx, uint64(1), time.Minute); ok {
+		t.Error("expected held lock not to be acquired")
+	}
+	if _, ok, _ := store.Lock(ctx, uint64(2), time.Minute); !ok {
+		t.Error("expected lock of another key to be acquired")
+	}
+
+	if err := store.Unlock(ctx, uint64(1), "foo"); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if _, ok, _ := store.Lock(ctx, uint64(1), time.Minute); ok {
+		t.Error("expected lock not to be released with a wrong token")
+	}
+
+	if err := store.Unlock(ctx, uint64(1), token); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if _, ok, _ := store.Lock(ctx, uint64(1), time.Millisecond); !ok {
+		t.Error("expected released lock to be acquired")
+	}
+
+	time.Sleep(2 * time.Millisecond)
+
+	if _, ok, _ := store.Lock(ctx, uint64(1), time.Minute); !ok {
+		t.Error("expected expired lock to be acquired")
+	}
+}
+
+func TestStoreDelete(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewStore(WithCapacity(10))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if err := store.Set(ctx, uint64(1), []byte("12345678"), time.Minute); err != nil {
+		t.Error("unexpected error", err)
+	}
+
+	if err := store.Delete(ctx, uint64(1)); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if _, err := store.Get(ctx, uint64(1)); err != httpcache.ErrNoEntry {
+		t.Errorf("expected error httpcache.ErrNoEntry, got %v", err)
+	}
+	if err := store.Delete(ctx, uint64(1)); err != nil {
+		t.Error("unexpected error", err)
+	}
+
+	// capacity is freed
+	if err := store.Set(ctx, uint64(2), []byte("12345678"), time.Minute); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if err := store.Set(ctx, uint64(3), []byte("12"), time.Minute); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if _, err := store.Get(ctx, uint64(2)); err != nil {
+		t.Errorf("expected data not to be evicted, got %v", err)
+	}
+}
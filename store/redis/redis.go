@@ -2,6 +2,8 @@ package redis
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strconv"
@@ -96,8 +98,54 @@ func (s *Store) Set(ctx context.Context, key uint64, data []byte, ttl time.Durat
 	return nil
 }
 
+func (s *Store) Delete(ctx context.Context, key uint64) error {
+	if err := s.client.Del(ctx, keyToString(key)).Err(); err != nil {
+		return fmt.Errorf("failed to delete: %v", err)
+	}
+	return nil
+}
+
+// unlockScript deletes the lock only if it's still held with the token.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Lock acquires the fill lock of key for ttl with SET NX, if it's not held
+// already.
+func (s *Store) Lock(ctx context.Context, key uint64, ttl time.Duration) (string, bool, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", false, fmt.Errorf("failed to generate token: %v", err)
+	}
+	token := hex.EncodeToString(buf[:])
+
+	acquired, err := s.client.SetNX(ctx, lockKey(key), token, ttl).Result()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to lock: %v", err)
+	}
+	return token, acquired, nil
+}
+
+// Unlock releases the fill lock of key if it's held with token.
+func (s *Store) Unlock(ctx context.Context, key uint64, token string) error {
+	if err := unlockScript.Run(ctx, s.client, []string{lockKey(key)}, token).Err(); err != nil {
+		return fmt.Errorf("failed to unlock: %v", err)
+	}
+	return nil
+}
+
+func lockKey(key uint64) string {
+	return "lock:" + keyToString(key)
+}
+
 func keyToString(key uint64) string {
 	return strconv.FormatUint(key, 10)
 }
 
-var _ httpcache.Store = (*Store)(nil)
+var (
+	_ httpcache.Store  = (*Store)(nil)
+	_ httpcache.Locker = (*Store)(nil)
+)
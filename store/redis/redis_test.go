@@ -44,3 +44,68 @@ func TestRedis(t *testing.T) {
 		t.Errorf("expected httpcache.ErrNoEntry, got %s", err)
 	}
 }
+
+func TestRedisLock(t *testing.T) {
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		t.Fatal("REDIS_ADDR is empty")
+	}
+
+	store, err := NewStore(WithRedisOptions(&redis.Options{Addr: redisAddr}))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	ctx := context.Background()
+
+	token, ok, err := store.Lock(ctx, uint64(3), time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected lock to be acquired, got %v, %v", ok, err)
+	}
+	if _, ok, _ := store.Lock(ctx, uint64(3), time.Minute); ok {
+		t.Error("expected held lock not to be acquired")
+	}
+
+	if err := store.Unlock(ctx, uint64(3), "foo"); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if _, ok, _ := store.Lock(ctx, uint64(3), time.Minute); ok {
+		t.Error("expected lock not to be released with a wrong token")
+	}
+
+	if err := store.Unlock(ctx, uint64(3), token); err != nil {
+		t.Error("unexpected error", err)
+	}
+	token, ok, err = store.Lock(ctx, uint64(3), time.Minute)
+	if err != nil || !ok {
+		t.Errorf("expected released lock to be acquired, got %v, %v", ok, err)
+	}
+	if err := store.Unlock(ctx, uint64(3), token); err != nil {
+		t.Error("unexpected error", err)
+	}
+}
+
+func TestRedisDelete(t *testing.T) {
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		t.Fatal("REDIS_ADDR is empty")
+	}
+
+	store, err := NewStore(WithRedisOptions(&redis.Options{Addr: redisAddr}))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	ctx := context.Background()
+
+	if err := store.Set(ctx, uint64(4), []byte("data"), time.Minute); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if err := store.Delete(ctx, uint64(4)); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if _, err := store.Get(ctx, uint64(4)); err != httpcache.ErrNoEntry {
+		t.Errorf("expected httpcache.ErrNoEntry, got %v", err)
+	}
+	if err := store.Delete(ctx, uint64(4)); err != nil {
+		t.Error("unexpected error", err)
+	}
+}
@@ -0,0 +1,458 @@
+// Package writebehind provides a store decorator writing cached responses
+// asynchronously, so that a slow store doesn't add latency to cache misses
+// and a client disconnecting doesn't cancel the write.
+package writebehind
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/uzzz/httpcache"
+)
+
+// ErrClosed is returned by Flush once the store is closed.
+var ErrClosed = errors.New("writebehind: store closed")
+
+// DropPolicy selects the write dropped when the queue of a worker is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the write being queued.
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest queued write to make room for the new one.
+	DropOldest
+	// Block waits for room in the queue until the context of the write is
+	// done, the write is dropped then.
+	Block
+)
+
+// OnDropFunc is called with the key of a dropped write.
+type OnDropFunc func(key uint64)
+
+func noopOnDropFunc(_ uint64) {}
+
+// Option is used to set Store settings.
+type Option func(o *Options) error
+
+type Options struct {
+	workers    int
+	queueSize  int
+	timeout    time.Duration
+	dropPolicy DropPolicy
+	onDrop     OnDropFunc
+	onError    httpcache.OnErrorFunc
+}
+
+var defaultOptions = Options{
+	workers:    4,
+	queueSize:  256,
+	timeout:    5 * time.Second,
+	dropPolicy: DropNewest,
+	onDrop:     noopOnDropFunc,
+	onError:    func(_ error) {},
+}
+
+// WithWorkers sets the number of workers writing to the store. Writes are
+// spread over workers by key, so that writes of a key are made in order.
+func WithWorkers(n int) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return errors.New("n must be > 0")
+		}
+
+		o.workers = n
+
+		return nil
+	}
+}
+
+// WithQueueSize sets the number of writes each worker can have queued.
+func WithQueueSize(size int) Option {
+	return func(o *Options) error {
+		if size <= 0 {
+			return errors.New("size must be > 0")
+		}
+
+		o.queueSize = size
+
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout of each write to the store.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *Options) error {
+		if timeout <= 0 {
+			return errors.New("timeout must be > 0")
+		}
+
+		o.timeout = timeout
+
+		return nil
+	}
+}
+
+// WithDropPolicy sets the write dropped when a queue is full. Defaults to
+// DropNewest.
+func WithDropPolicy(policy DropPolicy) Option {
+	return func(o *Options) error {
+		if policy < DropNewest || policy > Block {
+			return fmt.Errorf("unknown drop policy %d", policy)
+		}
+
+		o.dropPolicy = policy
+
+		return nil
+	}
+}
+
+// WithOnDropFunc sets the callback called when a write is dropped.
+func WithOnDropFunc(f OnDropFunc) Option {
+	return func(o *Options) error {
+		if f == nil {
+			return errors.New("function must not be nil")
+		}
+
+		o.onDrop = f
+
+		return nil
+	}
+}
+
+// WithOnErrorFunc sets the callback called when a queued write fails.
+func WithOnErrorFunc(f httpcache.OnErrorFunc) Option {
+	return func(o *Options) error {
+		if f == nil {
+			return errors.New("function must not be nil")
+		}
+
+		o.onError = f
+
+		return nil
+	}
+}
+
+// Store is a store writing to another store asynchronously. Values being
+// written are returned by Get, so that responses are served from the cache
+// as soon as they're stored. Deletes are made synchronously.
+type Store interface {
+	httpcache.Store
+
+	// Flush waits until the writes queued before the call are made, or
+	// the context is done.
+	Flush(ctx context.Context) error
+	// Close stops accepting writes and waits until the queued writes are
+	// made, or the context is done. Writes made after Close are written
+	// synchronously.
+	Close(ctx context.Context) error
+}
+
+// write is a queued write of the value of a key.
+type write struct {
+	key  uint64
+	data []byte
+	ttl  time.Duration
+}
+
+// worker makes the writes of the keys assigned to it. A slot is held for
+// each queued write, so that writes are queued without blocking once one is
+// acquired. The counters and the progress channel, closed and replaced on
+// each write taken off the queue, are guarded by the store mutex.
+type worker struct {
+	queue     chan *write
+	slots     chan struct{}
+	queued    uint64
+	processed uint64
+	progress  chan struct{}
+}
+
+type store struct {
+	next       httpcache.Store
+	workers    []*worker
+	timeout    time.Duration
+	dropPolicy DropPolicy
+	onDrop     OnDropFunc
+	onError    httpcache.OnErrorFunc
+
+	// closeMutex guards closed, the queues are closed under the write lock.
+	closeMutex sync.RWMutex
+	closed     bool
+	wg         sync.WaitGroup
+
+	// pending holds the latest queued write of each key. A write replaced
+	// or deleted before it's made is skipped. inflight holds the writes
+	// being made, closed once made, for deletes to wait for.
+	mutex    sync.Mutex
+	pending  map[uint64]*write
+	inflight map[uint64]chan struct{}
+}
+
+// lockerStore is a store passing the fill lock through to a store
+// implementing httpcache.Locker.
+type lockerStore struct {
+	*store
+	httpcache.Locker
+}
+
+// NewStore initializes a store writing to next asynchronously. The returned
+// store implements httpcache.Locker if next does.
+func NewStore(next httpcache.Store, opts ...Option) (Store, error) {
+	if next == nil {
+		return nil, errors.New("store must not be nil")
+	}
+
+	options := defaultOptions
+
+	for _, opt := range opts {
+		if err := opt(&options); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &store{
+		next:       next,
+		workers:    make([]*worker, options.workers),
+		timeout:    options.timeout,
+		dropPolicy: options.dropPolicy,
+		onDrop:     options.onDrop,
+		onError:    options.onError,
+		pending:    make(map[uint64]*write),
+		inflight:   make(map[uint64]chan struct{}),
+	}
+	for i := range s.workers {
+		s.workers[i] = &worker{
+			queue:    make(chan *write, options.queueSize),
+			slots:    make(chan struct{}, options.queueSize),
+			progress: make(chan struct{}),
+		}
+		s.wg.Add(1)
+		go s.run(s.workers[i])
+	}
+
+	if locker, ok := next.(httpcache.Locker); ok {
+		return lockerStore{s, locker}, nil
+	}
+	return s, nil
+}
+
+// Get returns the value being written for key, or gets it from the store.
+func (s *store) Get(ctx context.Context, key uint64) ([]byte, error) {
+	s.mutex.Lock()
+	w, ok := s.pending[key]
+	s.mutex.Unlock()
+	if ok {
+		return w.data, nil
+	}
+
+	return s.next.Get(ctx, key)
+}
+
+// Set queues the write of the value of key. It returns without waiting for
+// the write, whose failure is reported to the error callback.
+func (s *store) Set(ctx context.Context, key uint64, data []byte, ttl time.Duration) error {
+	s.closeMutex.RLock()
+	defer s.closeMutex.RUnlock()
+
+	if s.closed {
+		return s.next.Set(ctx, key, data, ttl)
+	}
+
+	if !s.enqueue(ctx, s.worker(key), &write{key: key, data: data, ttl: ttl}) {
+		s.onDrop(key)
+	}
+	return nil
+}
+
+// Delete removes the value of key, skipping its queued writes. A write of
+// key being made is waited for, not to overwrite the delete.
+func (s *store) Delete(ctx context.Context, key uint64) error {
+	s.mutex.Lock()
+	delete(s.pending, key)
+	inflight := s.inflight[key]
+	s.mutex.Unlock()
+
+	if inflight != nil {
+		select {
+		case <-inflight:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.next.Delete(ctx, key)
+}
+
+// Flush waits until the writes queued before the call are made.
+func (s *store) Flush(ctx context.Context) error {
+	s.closeMutex.RLock()
+	closed := s.closed
+	s.closeMutex.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	s.mutex.Lock()
+	queued := make([]uint64, len(s.workers))
+	for i, wk := range s.workers {
+		queued[i] = wk.queued
+	}
+	s.mutex.Unlock()
+
+	for i, wk := range s.workers {
+		for {
+			s.mutex.Lock()
+			processed, progress := wk.processed, wk.progress
+			s.mutex.Unlock()
+			if processed >= queued[i] {
+				break
+			}
+
+			select {
+			case <-progress:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops the workers once the queued writes are made.
+func (s *store) Close(ctx context.Context) error {
+	s.closeMutex.Lock()
+	if !s.closed {
+		s.closed = true
+		for _, wk := range s.workers {
+			close(wk.queue)
+		}
+	}
+	s.closeMutex.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enqueue queues w following the drop policy, making it the pending write
+// of its key. It returns false if w is dropped, the pending write of the key
+// is kept then.
+func (s *store) enqueue(ctx context.Context, wk *worker, w *write) bool {
+	select {
+	case wk.slots <- struct{}{}:
+	default:
+		switch s.dropPolicy {
+		case DropOldest:
+			return s.replaceOldest(wk, w)
+		case Block:
+			select {
+			case wk.slots <- struct{}{}:
+			case <-ctx.Done():
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	// w is made pending with the mutex held, for the worker not to skip it
+	// if it takes it off the queue right away.
+	s.mutex.Lock()
+	wk.queue <- w
+	wk.queued++
+	s.pending[w.key] = w
+	s.mutex.Unlock()
+	return true
+}
+
+// replaceOldest queues w in place of the oldest queued write of the worker,
+// which is dropped. If the queue was emptied meanwhile, w is queued as usual.
+func (s *store) replaceOldest(wk *worker, w *write) bool {
+	s.mutex.Lock()
+	var oldest *write
+	select {
+	case oldest = <-wk.queue:
+	default:
+		s.mutex.Unlock()
+		return s.enqueue(context.Background(), wk, w)
+	}
+	wk.queue <- w // takes over the slot of the oldest write
+	wk.queued++
+	wk.processed++
+	dropped := s.pending[oldest.key] == oldest
+	if dropped {
+		delete(s.pending, oldest.key)
+	}
+	s.pending[w.key] = w
+	s.mutex.Unlock()
+
+	if dropped {
+		s.onDrop(oldest.key)
+	}
+	return true
+}
+
+// run makes the writes queued to the worker until its queue is closed.
+func (s *store) run(wk *worker) {
+	defer s.wg.Done()
+
+	for w := range wk.queue {
+		<-wk.slots
+
+		if done, ok := s.start(w); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+			if err := s.next.Set(ctx, w.key, w.data, w.ttl); err != nil {
+				s.onError(fmt.Errorf("failed to write behind: %v", err))
+			}
+			cancel()
+
+			s.mutex.Lock()
+			delete(s.inflight, w.key)
+			if s.pending[w.key] == w {
+				delete(s.pending, w.key)
+			}
+			s.mutex.Unlock()
+			close(done)
+		}
+
+		s.mutex.Lock()
+		wk.processed++
+		close(wk.progress)
+		wk.progress = make(chan struct{})
+		s.mutex.Unlock()
+	}
+}
+
+func (s *store) worker(key uint64) *worker {
+	return s.workers[key%uint64(len(s.workers))]
+}
+
+// start marks w as being made, unless it's replaced or deleted. It returns
+// the channel to close once w is made.
+func (s *store) start(w *write) (chan struct{}, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.pending[w.key] != w {
+		return nil, false
+	}
+	done := make(chan struct{})
+	s.inflight[w.key] = done
+	return done, true
+}
+
+var (
+	_ Store            = (*store)(nil)
+	_ Store            = lockerStore{}
+	_ httpcache.Locker = lockerStore{}
+)
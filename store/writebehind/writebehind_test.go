@@ -0,0 +1,307 @@
+package writebehind
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/uzzz/httpcache"
+	"github.com/uzzz/httpcache/store/memory"
+)
+
+// gatedStore is a store whose writes wait until the gate is opened. Each
+// write is announced on started.
+type gatedStore struct {
+	httpcache.Store
+	gate    chan struct{}
+	started chan uint64
+	err     error
+}
+
+func newGatedStore(t *testing.T) *gatedStore {
+	t.Helper()
+
+	store, err := memory.NewStore()
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	return &gatedStore{
+		Store:   store,
+		gate:    make(chan struct{}),
+		started: make(chan uint64, 16),
+	}
+}
+
+func (s *gatedStore) Set(ctx context.Context, key uint64, data []byte, ttl time.Duration) error {
+	s.started <- key
+	<-s.gate
+	if s.err != nil {
+		return s.err
+	}
+	return s.Store.Set(ctx, key, data, ttl)
+}
+
+func newTestStore(t *testing.T, next httpcache.Store, opts ...Option) Store {
+	t.Helper()
+
+	store, err := NewStore(next, opts...)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	t.Cleanup(func() { _ = store.Close(context.Background()) })
+	return store
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	next := newGatedStore(t)
+	store := newTestStore(t, next)
+
+	if err := store.Set(ctx, uint64(1), []byte("data"), time.Minute); err != nil {
+		t.Error("unexpected error", err)
+	}
+	<-next.started
+
+	data, err := store.Get(ctx, uint64(1))
+	if err != nil {
+		t.Error("unexpected error", err)
+	}
+	if string(data) != "data" {
+		t.Errorf("expected pending write to be returned, got '%s'", string(data))
+	}
+	if _, err := next.Get(ctx, uint64(1)); err != httpcache.ErrNoEntry {
+		t.Errorf("expected write not to be made yet, got %v", err)
+	}
+
+	close(next.gate)
+	if err := store.Flush(ctx); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if data, err := next.Get(ctx, uint64(1)); err != nil || string(data) != "data" {
+		t.Errorf("expected write to be made, got '%s', %v", string(data), err)
+	}
+	if _, err := store.Get(ctx, uint64(2)); err != httpcache.ErrNoEntry {
+		t.Errorf("expected httpcache.ErrNoEntry, got %v", err)
+	}
+}
+
+func TestStoreDropPolicy(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   DropPolicy
+		dropped  uint64
+		expected []uint64
+	}{
+		{name: "drop newest", policy: DropNewest, dropped: 3, expected: []uint64{1, 2}},
+		{name: "drop oldest", policy: DropOldest, dropped: 2, expected: []uint64{1, 3}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := context.Background()
+			next := newGatedStore(t)
+
+			var (
+				mutex   sync.Mutex
+				dropped []uint64
+			)
+			store := newTestStore(t, next, WithWorkers(1), WithQueueSize(1), WithDropPolicy(testCase.policy),
+				WithOnDropFunc(func(key uint64) {
+					mutex.Lock()
+					dropped = append(dropped, key)
+					mutex.Unlock()
+				}))
+
+			_ = store.Set(ctx, uint64(1), []byte("data"), time.Minute)
+			<-next.started // the worker is busy, the next write is queued
+			_ = store.Set(ctx, uint64(2), []byte("data"), time.Minute)
+			_ = store.Set(ctx, uint64(3), []byte("data"), time.Minute)
+
+			mutex.Lock()
+			if len(dropped) != 1 || dropped[0] != testCase.dropped {
+				t.Errorf("expected write of key %d to be dropped, got %v", testCase.dropped, dropped)
+			}
+			mutex.Unlock()
+			if _, err := store.Get(ctx, testCase.dropped); err != httpcache.ErrNoEntry {
+				t.Errorf("expected httpcache.ErrNoEntry, got %v", err)
+			}
+
+			close(next.gate)
+			if err := store.Flush(ctx); err != nil {
+				t.Error("unexpected error", err)
+			}
+			for _, key := range testCase.expected {
+				if _, err := next.Get(ctx, key); err != nil {
+					t.Errorf("expected write of key %d to be made, got %v", key, err)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreDropNewestKeepsPending(t *testing.T) {
+	ctx := context.Background()
+	next := newGatedStore(t)
+	dropped := make(chan uint64, 1)
+	store := newTestStore(t, next, WithWorkers(1), WithQueueSize(1),
+		WithOnDropFunc(func(key uint64) { dropped <- key }))
+
+	_ = store.Set(ctx, uint64(1), []byte("data"), time.Minute)
+	<-next.started
+	_ = store.Set(ctx, uint64(2), []byte("v1"), time.Minute)
+	_ = store.Set(ctx, uint64(2), []byte("v2"), time.Minute)
+
+	if key := <-dropped; key != 2 {
+		t.Errorf("expected write of key 2 to be dropped, got %d", key)
+	}
+	if data, err := store.Get(ctx, uint64(2)); err != nil || string(data) != "v1" {
+		t.Errorf("expected queued write to be kept, got '%s', %v", string(data), err)
+	}
+
+	close(next.gate)
+	if err := store.Flush(ctx); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if data, err := next.Get(ctx, uint64(2)); err != nil || string(data) != "v1" {
+		t.Errorf("expected queued write to be made, got '%s', %v", string(data), err)
+	}
+}
+
+func TestStoreBlock(t *testing.T) {
+	next := newGatedStore(t)
+	dropped := make(chan uint64, 1)
+	store := newTestStore(t, next, WithWorkers(1), WithQueueSize(1), WithDropPolicy(Block),
+		WithOnDropFunc(func(key uint64) { dropped <- key }))
+
+	_ = store.Set(context.Background(), uint64(1), []byte("data"), time.Minute)
+	<-next.started
+	_ = store.Set(context.Background(), uint64(2), []byte("data"), time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_ = store.Set(ctx, uint64(3), []byte("data"), time.Minute)
+	if key := <-dropped; key != 3 {
+		t.Errorf("expected write of key 3 to be dropped, got %d", key)
+	}
+
+	close(next.gate)
+}
+
+func TestStoreDelete(t *testing.T) {
+	ctx := context.Background()
+	next := newGatedStore(t)
+	store := newTestStore(t, next, WithWorkers(1))
+
+	_ = store.Set(ctx, uint64(1), []byte("data"), time.Minute)
+	<-next.started
+	_ = store.Set(ctx, uint64(2), []byte("data"), time.Minute)
+
+	if err := store.Delete(ctx, uint64(2)); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if _, err := store.Get(ctx, uint64(2)); err != httpcache.ErrNoEntry {
+		t.Errorf("expected httpcache.ErrNoEntry, got %v", err)
+	}
+
+	close(next.gate)
+	if err := store.Flush(ctx); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if _, err := next.Get(ctx, uint64(2)); err != httpcache.ErrNoEntry {
+		t.Errorf("expected deleted write to be skipped, got %v", err)
+	}
+}
+
+func TestStoreClose(t *testing.T) {
+	ctx := context.Background()
+	next := newGatedStore(t)
+	store, err := NewStore(next)
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+
+	_ = store.Set(ctx, uint64(1), []byte("data"), time.Minute)
+	<-next.started
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := store.Close(timeoutCtx); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	close(next.gate)
+	if err := store.Close(ctx); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if _, err := next.Get(ctx, uint64(1)); err != nil {
+		t.Errorf("expected queued write to be made, got %v", err)
+	}
+
+	if err := store.Set(ctx, uint64(2), []byte("data"), time.Minute); err != nil {
+		t.Error("unexpected error", err)
+	}
+	<-next.started
+	if _, err := next.Get(ctx, uint64(2)); err != nil {
+		t.Errorf("expected write after close to be made synchronously, got %v", err)
+	}
+	if err := store.Flush(ctx); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestStoreError(t *testing.T) {
+	next := newGatedStore(t)
+	next.err = errors.New("connection refused")
+	close(next.gate)
+
+	errs := make(chan error, 1)
+	store := newTestStore(t, next, WithOnErrorFunc(func(err error) { errs <- err }))
+
+	if err := store.Set(context.Background(), uint64(1), []byte("data"), time.Minute); err != nil {
+		t.Error("unexpected error", err)
+	}
+	if err := <-errs; err == nil {
+		t.Error("expected error to be reported")
+	}
+}
+
+func TestStoreLocker(t *testing.T) {
+	locker, err := memory.NewStore()
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if _, ok := newTestStore(t, locker).(httpcache.Locker); !ok {
+		t.Error("expected store to implement httpcache.Locker")
+	}
+	if _, ok := newTestStore(t, newGatedStore(t)).(httpcache.Locker); ok {
+		t.Error("expected store not to implement httpcache.Locker")
+	}
+}
+
+func TestStoreOptions(t *testing.T) {
+	next := newGatedStore(t)
+	testCases := []struct {
+		name string
+		opt  Option
+	}{
+		{name: "workers", opt: WithWorkers(0)},
+		{name: "queue size", opt: WithQueueSize(0)},
+		{name: "timeout", opt: WithTimeout(0)},
+		{name: "drop policy", opt: WithDropPolicy(DropPolicy(-1))},
+		{name: "on drop", opt: WithOnDropFunc(nil)},
+		{name: "on error", opt: WithOnErrorFunc(nil)},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, err := NewStore(next, testCase.opt); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+	if _, err := NewStore(nil); err == nil {
+		t.Error("expected error for nil store")
+	}
+}
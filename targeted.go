@@ -0,0 +1,32 @@
+package httpcache
+
+import "net/http"
+
+// selectCacheControl sets the directives of the first targeted cache control
+// field configured which the response has a valid value for (RFC 9213,
+// section 2.2).
+func (m middleware) selectCacheControl(res *cachedResponse) {
+	for _, k := range m.targetedFields {
+		if v := res.Header.Values(k); len(parseCacheControl(v)) > 0 {
+			res.CacheControl = v
+			return
+		}
+	}
+}
+
+// cacheControl returns the directives of the targeted cache control field
+// selected for the response, or of its Cache-Control header if none was.
+func (cr cachedResponse) cacheControl() cacheControl {
+	if cr.CacheControl != nil {
+		return parseCacheControl(cr.CacheControl)
+	}
+	return parseCacheControl(cr.Header.Values("Cache-Control"))
+}
+
+// removeTargetedFields removes the targeted cache control fields from the
+// header, as they're meant for the cache only.
+func (m middleware) removeTargetedFields(h http.Header) {
+	for _, k := range m.targetedFields {
+		delete(h, k)
+	}
+}
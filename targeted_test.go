@@ -0,0 +1,119 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareTargetedCacheControl(t *testing.T) {
+	testCases := []struct {
+		name        string
+		fields      []string
+		header      http.Header
+		expectedSet int
+		expectedTTL time.Duration
+	}{
+		{
+			name:        "cdn-cache-control",
+			fields:      []string{"CDN-Cache-Control"},
+			header:      http.Header{"Cache-Control": {"no-store"}, "Cdn-Cache-Control": {"max-age=60"}},
+			expectedSet: 1,
+			expectedTTL: time.Minute,
+		},
+		{
+			name:   "expires ignored",
+			fields: []string{"CDN-Cache-Control"},
+			header: http.Header{
+				"Expires":           {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)},
+				"Cdn-Cache-Control": {"max-age=60"},
+			},
+			expectedSet: 1,
+			expectedTTL: time.Minute,
+		},
+		{
+			name:        "targeted no-store",
+			fields:      []string{"CDN-Cache-Control"},
+			header:      http.Header{"Cache-Control": {"max-age=60"}, "Cdn-Cache-Control": {"no-store"}},
+			expectedSet: 0,
+		},
+		{
+			name:   "precedence",
+			fields: []string{"Example-CDN-Cache-Control", "CDN-Cache-Control"},
+			header: http.Header{
+				"Cdn-Cache-Control":         {"max-age=60"},
+				"Example-Cdn-Cache-Control": {"max-age=120"},
+			},
+			expectedSet: 1,
+			expectedTTL: 2 * time.Minute,
+		},
+		{
+			name:        "surrogate-control",
+			fields:      []string{"Surrogate-Control"},
+			header:      http.Header{"Cache-Control": {"max-age=0"}, "Surrogate-Control": {"max-age=60"}},
+			expectedSet: 1,
+			expectedTTL: time.Minute,
+		},
+		{
+			name:        "fallback to cache-control",
+			fields:      []string{"CDN-Cache-Control"},
+			header:      http.Header{"Cache-Control": {"max-age=30"}, "Cdn-Cache-Control": {""}},
+			expectedSet: 1,
+			expectedTTL: 30 * time.Second,
+		},
+		{
+			name:        "not configured",
+			header:      http.Header{"Cache-Control": {"no-store"}, "Cdn-Cache-Control": {"max-age=60"}},
+			expectedSet: 0,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			store := &testStore{}
+			options := []Option{WithStaleTTL(0)}
+			if testCase.fields != nil {
+				options = append(options, WithTargetedCacheControl(testCase.fields...))
+			}
+			mw, err := NewMiddleware(store, options...)
+			if err != nil {
+				t.Fatal("unexpected error", err)
+			}
+			handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				copyHeader(w.Header(), testCase.header)
+				_, _ = w.Write([]byte("hello"))
+			}))
+
+			for i := 0; i < 2; i++ { // miss, then hit
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+				for _, k := range testCase.fields {
+					if v := rr.Header().Get(k); v != "" {
+						t.Errorf("expected %s to be removed, got '%s'", k, v)
+					}
+				}
+				if cc, expected := rr.Header().Get("Cache-Control"), testCase.header.Get("Cache-Control"); cc != expected {
+					t.Errorf("expected Cache-Control to be '%s', got '%s'", expected, cc)
+				}
+			}
+
+			if store.setCalled != testCase.expectedSet {
+				t.Errorf("expected store.Set to be called %d times, got %d", testCase.expectedSet, store.setCalled)
+			}
+			if diff := store.lastTTL - testCase.expectedTTL; diff > time.Second || diff < -time.Second {
+				t.Errorf("expected ttl to be %v, got %v", testCase.expectedTTL, store.lastTTL)
+			}
+		})
+	}
+}
+
+func TestTargetedCacheControlOptions(t *testing.T) {
+	if _, err := NewMiddleware(&testStore{}, WithTargetedCacheControl()); err == nil {
+		t.Error("expected error for empty fields")
+	}
+	if _, err := NewMiddleware(&testStore{}, WithTargetedCacheControl("")); err == nil {
+		t.Error("expected error for empty field")
+	}
+}
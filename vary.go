@@ -0,0 +1,83 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// varyFields returns sorted canonical names of the request header fields
+// listed in the Vary response header. "*" is returned as is.
+func varyFields(h http.Header) []string {
+	seen := make(map[string]bool)
+	var fields []string
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			if field != "*" {
+				field = textproto.CanonicalMIMEHeaderKey(field)
+			}
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// equalFields reports whether the sorted lists of fields are the same.
+func equalFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func varyAll(fields []string) bool {
+	for _, field := range fields {
+		if field == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// secondaryKey generates the key of the response variant selected by the
+// values of the given request header fields.
+func (m middleware) secondaryKey(key uint64, fields []string, h http.Header) uint64 {
+	var b strings.Builder
+	b.WriteString(strconv.FormatUint(key, 10))
+	for _, field := range fields {
+		b.WriteByte('\n')
+		b.WriteString(field)
+		b.WriteByte(':')
+		b.WriteString(normalizeFieldValue(h.Values(field)))
+	}
+	return m.keygen.Generate(b.String())
+}
+
+// normalizeFieldValue combines multiple field lines and removes
+// insignificant whitespace (RFC 9111, section 4.1).
+func normalizeFieldValue(values []string) string {
+	var elems []string
+	for _, value := range values {
+		for _, elem := range strings.Split(value, ",") {
+			if elem = strings.TrimSpace(elem); elem != "" {
+				elems = append(elems, elem)
+			}
+		}
+	}
+	return strings.Join(elems, ",")
+}
@@ -0,0 +1,82 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_varyFields(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   http.Header
+		expected []string
+	}{
+		{name: "none", header: http.Header{}, expected: nil},
+		{
+			name:     "single",
+			header:   http.Header{"Vary": {"accept-language"}},
+			expected: []string{"Accept-Language"},
+		},
+		{
+			name:     "multiple values sorted and deduplicated",
+			header:   http.Header{"Vary": {"Accept-Language, Accept-Encoding", "accept-encoding"}},
+			expected: []string{"Accept-Encoding", "Accept-Language"},
+		},
+		{
+			name:     "asterisk",
+			header:   http.Header{"Vary": {"*"}},
+			expected: []string{"*"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if fields := varyFields(testCase.header); !reflect.DeepEqual(testCase.expected, fields) {
+				t.Errorf("expected %v, got %v", testCase.expected, fields)
+			}
+		})
+	}
+}
+
+func Test_normalizeFieldValue(t *testing.T) {
+	a := normalizeFieldValue([]string{"gzip,  br"})
+	b := normalizeFieldValue([]string{"gzip", "br"})
+	if a != b {
+		t.Errorf("expected '%s' to be equal to '%s'", a, b)
+	}
+}
+
+func TestMiddlewareVaryPrimaryTTL(t *testing.T) {
+	mw, err := NewMiddleware(&testStore{})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Language")
+		if r.Header.Get("Accept-Language") == "en" {
+			w.Header().Set("Cache-Control", "max-age=3600")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=5")
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	for _, lang := range []string{"en", "de"} {
+		handler.ServeHTTP(httptest.NewRecorder(), newRequestBuilder().withMethod(http.MethodGet).withPath("/").
+			withHeader("Accept-Language", lang).build())
+	}
+
+	m := handler.(*middleware)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	primary, err := m.getCachedResponse(context.Background(), m.generateKey(req.URL))
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if keep := time.Until(primary.KeepUntil); keep < 59*time.Minute {
+		t.Errorf("expected primary entry to be kept as long as the longest-lived variant, got %s", keep)
+	}
+}